@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/influenzanet/api-gateway/internal/config"
+	gc "github.com/influenzanet/api-gateway/pkg/grpc/clients"
+	"github.com/influenzanet/api-gateway/pkg/models"
+	v1 "github.com/influenzanet/api-gateway/pkg/protocols/http/v1"
+)
+
+func main() {
+	conf := config.InitConfig()
+	if conf.DebugMode {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	clients := &models.APIClients{}
+
+	umClient, userManagementServiceClose := gc.ConnectToUserManagementService(conf.ServiceURLs.UserManagement)
+	defer userManagementServiceClose()
+	studyClient, studyServiceClose := gc.ConnectToStudyService(conf.ServiceURLs.StudyService)
+	defer studyServiceClose()
+	messagingClient, messagingServiceClose := gc.ConnectToMessagingService(conf.ServiceURLs.MessagingService)
+	defer messagingServiceClose()
+
+	clients.UserManagement = umClient
+	clients.StudyService = studyClient
+	clients.MessagingService = messagingClient
+
+	router := gin.Default()
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     conf.AllowOrigins,
+		AllowMethods:     []string{"POST", "GET", "PUT", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Authorization"},
+		ExposeHeaders:    []string{"Authorization", "Content-Type", "Content-Length"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+
+	router.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	root := router.Group("")
+
+	v1Root := root.Group("/v1")
+	v1APIHandlers := v1.NewHTTPHandler(clients, conf.UseEndpoints)
+	v1APIHandlers.AddServiceStatusAPI(v1Root)
+	v1APIHandlers.AddParticipantAuthAPI(v1Root)
+	v1APIHandlers.AddUserManagementParticipantAPI(v1Root)
+	v1APIHandlers.AddStudyServiceParticipantAPI(v1Root)
+	v1APIHandlers.AddPhoneParticipantAPI(v1Root)
+
+	log.Printf("gateway listening on port %s", conf.Port)
+	log.Fatal(router.Run(":" + conf.Port))
+}
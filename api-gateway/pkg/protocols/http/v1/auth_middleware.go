@@ -0,0 +1,164 @@
+package v1
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	umAPI "github.com/influenzanet/user-management-service/pkg/api"
+)
+
+// Context keys set by RequireAccessToken
+const (
+	ContextKeyAccessToken = "accessToken"
+	ContextKeyUserID      = "userID"
+	ContextKeyInstanceID  = "instanceID"
+)
+
+// extractBearerToken returns the token of an "Authorization: Bearer <token>" header value.
+func extractBearerToken(header string) (string, bool) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// RequireAccessToken extracts the bearer token from the Authorization header, validates it
+// with the user management service and stores the token, userID and instanceID in the
+// context. Requests without a valid token are aborted with 401.
+func RequireAccessToken(umClient umAPI.UserManagementApiClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := extractBearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ApiResponse{
+				Success: false,
+				Message: "Missing authorization token",
+			})
+			return
+		}
+
+		tokenInfos, err := umClient.ValidateJWT(c, &umAPI.JWTRequest{Token: token})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ApiResponse{
+				Success: false,
+				Message: "Invalid authorization token",
+			})
+			return
+		}
+
+		c.Set(ContextKeyAccessToken, token)
+		c.Set(ContextKeyUserID, tokenInfos.Id)
+		c.Set(ContextKeyInstanceID, tokenInfos.InstanceId)
+		c.Next()
+	}
+}
+
+// AddPhoneVerificationRoutes registers the phone add/change/remove routes. All of them but the
+// revoke link require a valid access token. Pending verifications are completed through the
+// routes of HttpEndpoints.AddPhoneVerificationRoutes.
+func (h *UserManagementHandlers) AddPhoneVerificationRoutes(rg *gin.RouterGroup) {
+	contactGroup := rg.Group("/contact")
+	contactGroup.Use(RequireAccessToken(h.userManagementClient))
+	{
+		contactGroup.POST("/add-phone", h.AddPhoneNumberHandler)
+		contactGroup.POST("/change-phone", h.ChangePhoneNumberHandler)
+		contactGroup.POST("/remove-phone", h.RemovePhoneNumberHandler)
+		contactGroup.GET("/phones", h.ListPhoneNumbersHandler)
+		contactGroup.POST("/phones/primary", h.SetPrimaryPhoneNumberHandler)
+		contactGroup.POST("/phones/channels", h.SetPhoneNumberChannelsHandler)
+		contactGroup.POST("/phones/verify", h.RequestPhoneVerificationHandler)
+		contactGroup.POST("/phones/whatsapp-consent", h.SetWhatsAppConsentHandler)
+	}
+
+	adminGroup := rg.Group("/admin")
+	adminGroup.Use(RequireAccessToken(h.userManagementClient))
+	{
+		adminGroup.POST("/phones/reverification", h.StartPhoneReverificationHandler)
+		adminGroup.GET("/whatsapp/templates", h.GetWhatsAppTemplateStatusHandler)
+	}
+
+	// Opened from the notification email, possibly by someone who lost access to the account
+	rg.POST("/contact/revoke-phone-change", h.RevokePhoneChangeHandler)
+}
+
+// AddNotificationPreferencesRoutes registers reading and updating the channel the logged in user
+// receives each message type on.
+func (h *UserManagementHandlers) AddNotificationPreferencesRoutes(rg *gin.RouterGroup) {
+	preferencesGroup := rg.Group("/notification-preferences")
+	preferencesGroup.Use(RequireAccessToken(h.userManagementClient))
+	{
+		preferencesGroup.GET("", h.GetNotificationPreferencesHandler)
+		preferencesGroup.POST("", h.UpdateNotificationPreferencesHandler)
+	}
+}
+
+// AddPhoneVerificationRoutes registers the routes completing, resending or cancelling a
+// pending phone verification. All of them require a valid access token.
+func (h *HttpEndpoints) AddPhoneVerificationRoutes(rg *gin.RouterGroup) {
+	contactGroup := rg.Group("/contact")
+	contactGroup.Use(RequireAccessToken(h.clients.UserManagement))
+	{
+		contactGroup.POST("/verify-phone", h.verifyPhoneNumber)
+		contactGroup.POST("/resend-verification", h.resendPhoneVerificationCode)
+		contactGroup.POST("/cancel-verification", h.cancelPhoneVerification)
+	}
+}
+
+// AddPhoneVerificationFallbackRoutes registers the page behind the email link sent when a
+// verification code could not be delivered to the phone. It is authenticated by the link token.
+func (h *HttpEndpoints) AddPhoneVerificationFallbackRoutes(rg *gin.RouterGroup) {
+	rg.POST("/contact/phone-verification-fallback", h.startReverseVerification)
+}
+
+// AddPhoneLoginRoutes registers the passwordless login with a code sent to a verified phone
+// number and the completion of a login challenged for a second factor. These routes are public;
+// the service refuses phone login for instances without it.
+func (h *HttpEndpoints) AddPhoneLoginRoutes(rg *gin.RouterGroup) {
+	authGroup := rg.Group("/auth")
+	{
+		authGroup.POST("/login-with-phone/request-code", h.requestPhoneLoginCode)
+		authGroup.POST("/login-with-phone", h.loginWithPhoneCode)
+		authGroup.POST("/second-factor/verify", h.verifySecondFactor)
+	}
+}
+
+// AddTelegramWebhookRoutes registers the webhook the Telegram bot delivers updates to. It is
+// authenticated by the webhook secret token, not an access token.
+func (h *HttpEndpoints) AddTelegramWebhookRoutes(rg *gin.RouterGroup) {
+	rg.POST("/telegram/webhook", h.telegramWebhook)
+}
+
+// AddWhatsAppWebhookRoutes registers the webhook of the WhatsApp business number. Notifications
+// are authenticated by their signature, the subscription check by the verify token.
+func (h *HttpEndpoints) AddWhatsAppWebhookRoutes(rg *gin.RouterGroup) {
+	rg.GET("/whatsapp/webhook", h.whatsappWebhookVerify)
+	rg.POST("/whatsapp/webhook", h.whatsappWebhook)
+}
+
+// AddSecondFactorRoutes registers the management of the phone second factor of the logged in
+// user.
+func (h *HttpEndpoints) AddSecondFactorRoutes(rg *gin.RouterGroup) {
+	secondFactorGroup := rg.Group("/second-factor")
+	secondFactorGroup.Use(RequireAccessToken(h.clients.UserManagement))
+	{
+		secondFactorGroup.POST("/phone/enable", h.enablePhoneSecondFactor)
+		secondFactorGroup.POST("/phone/disable", h.disablePhoneSecondFactor)
+		secondFactorGroup.POST("/recovery-codes", h.regenerateRecoveryCodes)
+	}
+}
+
+// AddPhoneParticipantAPI registers every phone related route of the participant API. The
+// participant API router calls it with the /v1 group, next to the other Add...API functions.
+func (h *HttpEndpoints) AddPhoneParticipantAPI(rg *gin.RouterGroup) {
+	umHandlers := &UserManagementHandlers{userManagementClient: h.clients.UserManagement}
+	umHandlers.AddPhoneVerificationRoutes(rg)
+	umHandlers.AddNotificationPreferencesRoutes(rg)
+
+	h.AddPhoneVerificationRoutes(rg)
+	h.AddPhoneVerificationFallbackRoutes(rg)
+	h.AddPhoneLoginRoutes(rg)
+	h.AddSecondFactorRoutes(rg)
+	h.AddTelegramWebhookRoutes(rg)
+	h.AddWhatsAppWebhookRoutes(rg)
+}
@@ -0,0 +1,115 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	umAPI "github.com/influenzanet/user-management-service/pkg/api"
+	"google.golang.org/grpc"
+)
+
+// fakeUserManagementClient accepts "valid-token" as the token of user-1 in instance-1. Calls of
+// other methods panic through the nil embedded client.
+type fakeUserManagementClient struct {
+	umAPI.UserManagementApiClient
+}
+
+func (fakeUserManagementClient) ValidateJWT(ctx context.Context, in *umAPI.JWTRequest, opts ...grpc.CallOption) (*umAPI.TokenInfos, error) {
+	if in.Token != "valid-token" {
+		return nil, errors.New("invalid token")
+	}
+	return &umAPI.TokenInfos{Id: "user-1", InstanceId: "instance-1"}, nil
+}
+
+func TestExtractBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer abc", "abc", true},
+		{"Bearer", "", false},
+		{"Basic abc", "", false},
+		{"Bearer abc def", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			token, ok := extractBearerToken(tt.header)
+			if token != tt.token || ok != tt.ok {
+				t.Errorf("expected %q, %v, got %q, %v", tt.token, tt.ok, token, ok)
+			}
+		})
+	}
+}
+
+func TestRequireAccessToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/protected", RequireAccessToken(fakeUserManagementClient{}), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"accessToken": c.GetString(ContextKeyAccessToken),
+			"userID":      c.GetString(ContextKeyUserID),
+			"instanceID":  c.GetString(ContextKeyInstanceID),
+		})
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"other scheme", "Basic valid-token", http.StatusUnauthorized},
+		{"invalid token", "Bearer other-token", http.StatusUnauthorized},
+		{"valid token", "Bearer valid-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != `{"accessToken":"valid-token","instanceID":"instance-1","userID":"user-1"}` {
+				t.Errorf("unexpected context values: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAddPhoneVerificationRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	h := &UserManagementHandlers{userManagementClient: fakeUserManagementClient{}}
+	h.AddPhoneVerificationRoutes(router.Group("/v1"))
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"phone routes require a token", "/v1/contact/add-phone", http.StatusUnauthorized},
+		{"admin routes require a token", "/v1/admin/phones/reverification", http.StatusUnauthorized},
+		{"legacy WhatsApp routes are gone", "/v1/whatsapp-verification/initiate", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
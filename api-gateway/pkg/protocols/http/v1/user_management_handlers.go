@@ -2,49 +2,168 @@ package v1
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/influenzanet/api-gateway/pkg/models"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type AddPhoneRequest struct {
 	PhoneNumber        string `json:"phoneNumber" binding:"required"`
 	VerificationMethod string `json:"verificationMethod,omitempty"`
+	// Store the number unverified, to be verified later through /contact/phones/verify
+	SkipVerification bool `json:"skipVerification,omitempty"`
+	// Opt-in to WhatsApp messages at this number, recorded once it is verified
+	WhatsAppOptIn bool `json:"whatsappOptIn,omitempty"`
+}
+
+type RequestPhoneVerificationRequest struct {
+	ContactID          string `json:"contactId" binding:"required"`
+	VerificationMethod string `json:"verificationMethod,omitempty"`
+}
+
+type StartPhoneReverificationRequest struct {
+	// Overrides the instance's reverifyAfterDays setting
+	MaxAgeDays int `json:"maxAgeDays,omitempty"`
 }
 
 type ChangePhoneRequest struct {
 	NewPhoneNumber     string `json:"newPhoneNumber" binding:"required"`
 	VerificationMethod string `json:"verificationMethod,omitempty"`
+	// Phone contact to change, the primary one if empty
+	ContactID string `json:"contactId,omitempty"`
+	// Where to send the code proving possession of the current number, if the instance
+	// requires it: "phone" (default) or "email"
+	OldNumberProofMethod string `json:"oldNumberProofMethod,omitempty"`
+	// Opt-in to WhatsApp messages at the new number, recorded once it is verified
+	WhatsAppOptIn bool `json:"whatsappOptIn,omitempty"`
 }
 
-type WhatsAppVerificationRequest struct {
-	Token       string `json:"token" binding:"required"`
-	Code        string `json:"code" binding:"required"`
-	PhoneNumber string `json:"phoneNumber,omitempty"`
+type SetPrimaryPhoneRequest struct {
+	ContactID string `json:"contactId" binding:"required"`
+	Password  string `json:"password,omitempty"`
 }
 
-type InitiateVerificationRequest struct {
-	PhoneNumber        string `json:"phoneNumber" binding:"required"`
-	VerificationMethod string `json:"verificationMethod" binding:"required"`
+type SetPhoneChannelsRequest struct {
+	ContactID string   `json:"contactId" binding:"required"`
+	Channels  []string `json:"channels"`
 }
 
-type ResendVerificationRequest struct {
-	Token string `json:"token" binding:"required"`
+type UpdateNotificationPreferencesRequest struct {
+	// Channel ("email", "whatsapp", "sms") per message type ("newsletter", "weekly-reminder",
+	// "account-security"); an empty channel resets the type to its default
+	Channels map[string]string `json:"channels" binding:"required"`
+}
+
+type SetWhatsAppConsentRequest struct {
+	ContactID string `json:"contactId" binding:"required"`
+	Granted   bool   `json:"granted"`
 }
 
-type CancelVerificationRequest struct {
+type RemovePhoneRequest struct {
+	ContactID string `json:"contactId" binding:"required"`
+	Password  string `json:"password,omitempty"`
+}
+
+type RevokePhoneChangeRequest struct {
 	Token string `json:"token" binding:"required"`
 }
 
 type ApiResponse struct {
-	Success             bool        `json:"success"`
-	Data                interface{} `json:"data,omitempty"`
-	Message             string      `json:"message,omitempty"`
-	VerificationToken   string      `json:"verificationToken,omitempty"`
-	ExpiresAt           *time.Time  `json:"expiresAt,omitempty"`
-	AttemptsRemaining   *int        `json:"attemptsRemaining,omitempty"`
+	Success           bool        `json:"success"`
+	Data              interface{} `json:"data,omitempty"`
+	Message           string      `json:"message,omitempty"`
+	Reason            string      `json:"reason,omitempty"`
+	VerificationToken string      `json:"verificationToken,omitempty"`
+	ExpiresAt         *time.Time  `json:"expiresAt,omitempty"`
+	AttemptsRemaining *int        `json:"attemptsRemaining,omitempty"`
+}
+
+// VerificationPendingResponse is returned when a phone number change has been requested
+// but not yet confirmed: the number is only added or changed once the code is verified.
+type VerificationPendingResponse struct {
+	Success            bool      `json:"success"`
+	Status             string    `json:"status"`
+	VerificationToken  string    `json:"verificationToken"`
+	VerificationMethod string    `json:"verificationMethod"`
+	MaskedDestination  string    `json:"maskedDestination"`
+	Step               string    `json:"step,omitempty"`
+	DeepLink           string    `json:"deepLink,omitempty"`
+	ExpiresAt          time.Time `json:"expiresAt"`
+	ResendAvailableAt  time.Time `json:"resendAvailableAt"`
+	AttemptsRemaining  int       `json:"attemptsRemaining"`
+	Message            string    `json:"message,omitempty"`
+	Warning            string    `json:"warning,omitempty"` // e.g. PHONE_ALREADY_IN_USE: accepted with a caveat
+	// whatsapp-reverse only: no code is sent, the user sends this message from the phone
+	ReverseInstructions *api.ReverseVerificationInstructions `json:"reverseInstructions,omitempty"`
+}
+
+// pendingVerificationMessage tells the user where to find the code of a pending verification.
+func pendingVerificationMessage(reverseInstructions *api.ReverseVerificationInstructions) string {
+	if reverseInstructions != nil {
+		return "Send the message shown from your phone to complete the verification"
+	}
+	return "Verification code sent"
+}
+
+// respondIfRateLimited answers with 429 and a Retry-After header if err is a ResourceExhausted
+// gRPC error, and reports whether it did so.
+func respondIfRateLimited(c *gin.Context, err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return false
+	}
+
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			retryAfter := int(retryInfo.GetRetryDelay().AsDuration().Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			break
+		}
+	}
+	c.JSON(http.StatusTooManyRequests, ApiResponse{
+		Success: false,
+		Message: st.Message(),
+	})
+	return true
+}
+
+// respondIfPhoneRejected answers with 400 (InvalidArgument) or 409 (AlreadyExists) if the phone
+// number was refused, forwarding the ErrorInfo reason so the client can show a localized
+// message, and reports whether it did so.
+func respondIfPhoneRejected(c *gin.Context, err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+
+	httpStatus := http.StatusBadRequest
+	switch st.Code() {
+	case codes.InvalidArgument:
+	case codes.AlreadyExists:
+		httpStatus = http.StatusConflict
+	default:
+		return false
+	}
+
+	response := ApiResponse{
+		Success: false,
+		Message: st.Message(),
+	}
+	for _, detail := range st.Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok {
+			response.Reason = errorInfo.GetReason()
+			break
+		}
+	}
+	c.JSON(httpStatus, response)
+	return true
 }
 
 func (h *UserManagementHandlers) AddPhoneNumberHandler(c *gin.Context) {
@@ -57,22 +176,21 @@ func (h *UserManagementHandlers) AddPhoneNumberHandler(c *gin.Context) {
 		return
 	}
 
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, ApiResponse{
-			Success: false,
-			Message: "Missing authorization token",
-		})
-		return
-	}
+	token := c.GetString(ContextKeyAccessToken)
 
 	response, err := h.userManagementClient.AddPhoneNumber(c, &api.AddPhoneNumberRequest{
-		Token:       token,
-		PhoneNumber: req.PhoneNumber,
+		Token:              token,
+		PhoneNumber:        req.PhoneNumber,
 		VerificationMethod: req.VerificationMethod,
+		ClientIp:           c.ClientIP(),
+		SkipVerification:   req.SkipVerification,
+		WhatsappOptIn:      req.WhatsAppOptIn,
 	})
 
 	if err != nil {
+		if respondIfRateLimited(c, err) || respondIfPhoneRejected(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, ApiResponse{
 			Success: false,
 			Message: "Failed to add phone number",
@@ -80,10 +198,30 @@ func (h *UserManagementHandlers) AddPhoneNumberHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, ApiResponse{
-		Success:           response.Success,
-		VerificationToken: response.VerificationToken,
-		Message:           "Phone number added successfully",
+	if req.SkipVerification {
+		c.JSON(http.StatusCreated, ApiResponse{
+			Success: response.Success,
+			Data:    gin.H{"contactId": response.ContactId, "warning": response.Warning},
+			Message: "Phone number added, not verified yet",
+		})
+		return
+	}
+
+	// The phone number is only attached to the account once the code has been verified,
+	// so report the pending verification instead of a completed change.
+	c.JSON(http.StatusAccepted, VerificationPendingResponse{
+		Success:             response.Success,
+		Status:              "pending",
+		VerificationToken:   response.VerificationToken,
+		VerificationMethod:  response.VerificationMethod,
+		MaskedDestination:   response.MaskedPhoneNumber,
+		DeepLink:            response.DeepLink,
+		ExpiresAt:           time.Unix(response.ExpiresAt, 0),
+		ResendAvailableAt:   time.Unix(response.ResendAvailableAt, 0),
+		AttemptsRemaining:   int(response.AttemptsRemaining),
+		Message:             pendingVerificationMessage(response.ReverseInstructions),
+		Warning:             response.Warning,
+		ReverseInstructions: response.ReverseInstructions,
 	})
 }
 
@@ -97,38 +235,69 @@ func (h *UserManagementHandlers) ChangePhoneNumberHandler(c *gin.Context) {
 		return
 	}
 
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, ApiResponse{
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.EditPhoneNumber(c, &api.EditPhoneNumberRequest{
+		Token:                token,
+		NewPhoneNumber:       req.NewPhoneNumber,
+		VerificationMethod:   req.VerificationMethod,
+		ClientIp:             c.ClientIP(),
+		ContactId:            req.ContactID,
+		OldNumberProofMethod: req.OldNumberProofMethod,
+		WhatsappOptIn:        req.WhatsAppOptIn,
+	})
+
+	if err != nil {
+		if respondIfRateLimited(c, err) || respondIfPhoneRejected(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ApiResponse{
 			Success: false,
-			Message: "Missing authorization token",
+			Message: "Failed to change phone number",
 		})
 		return
 	}
 
-	response, err := h.userManagementClient.EditPhoneNumber(c, &api.EditPhoneNumberRequest{
-		Token:          token,
-		NewPhoneNumber: req.NewPhoneNumber,
-		VerificationMethod: req.VerificationMethod,
+	c.JSON(http.StatusAccepted, VerificationPendingResponse{
+		Success:             response.Success,
+		Status:              "pending",
+		VerificationToken:   response.VerificationToken,
+		VerificationMethod:  response.VerificationMethod,
+		MaskedDestination:   response.MaskedPhoneNumber,
+		DeepLink:            response.DeepLink,
+		Step:                response.Step,
+		ExpiresAt:           time.Unix(response.ExpiresAt, 0),
+		ResendAvailableAt:   time.Unix(response.ResendAvailableAt, 0),
+		AttemptsRemaining:   int(response.AttemptsRemaining),
+		Message:             pendingVerificationMessage(response.ReverseInstructions),
+		Warning:             response.Warning,
+		ReverseInstructions: response.ReverseInstructions,
+	})
+}
+
+func (h *UserManagementHandlers) ListPhoneNumbersHandler(c *gin.Context) {
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.ListPhoneNumbers(c, &api.ListPhoneNumbersRequest{
+		Token: token,
 	})
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ApiResponse{
 			Success: false,
-			Message: "Failed to change phone number",
+			Message: "Failed to list phone numbers",
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, ApiResponse{
-		Success:           response.Success,
-		VerificationToken: response.VerificationToken,
-		Message:           "Phone number changed successfully",
+		Success: true,
+		Data:    response.PhoneNumbers,
 	})
 }
 
-func (h *UserManagementHandlers) InitiateWhatsAppVerificationHandler(c *gin.Context) {
-	var req InitiateVerificationRequest
+func (h *UserManagementHandlers) SetPrimaryPhoneNumberHandler(c *gin.Context) {
+	var req SetPrimaryPhoneRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ApiResponse{
 			Success: false,
@@ -137,42 +306,113 @@ func (h *UserManagementHandlers) InitiateWhatsAppVerificationHandler(c *gin.Cont
 		return
 	}
 
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, ApiResponse{
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.SetPrimaryPhoneNumber(c, &api.SetPrimaryPhoneNumberRequest{
+		Token:     token,
+		ContactId: req.ContactID,
+		Password:  req.Password,
+		ClientIp:  c.ClientIP(),
+	})
+
+	if err != nil {
+		if respondIfRateLimited(c, err) {
+			return
+		}
+		switch status.Code(err) {
+		case codes.PermissionDenied:
+			c.JSON(http.StatusForbidden, ApiResponse{
+				Success: false,
+				Message: "Re-authentication required",
+			})
+		case codes.NotFound:
+			c.JSON(http.StatusNotFound, ApiResponse{
+				Success: false,
+				Message: "Phone number not found",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to set primary phone number",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    response.PhoneNumbers,
+	})
+}
+
+func (h *UserManagementHandlers) SetPhoneNumberChannelsHandler(c *gin.Context) {
+	var req SetPhoneChannelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ApiResponse{
 			Success: false,
-			Message: "Missing authorization token",
+			Message: "Invalid request format",
 		})
 		return
 	}
 
-	response, err := h.whatsappClient.InitiateVerification(c, &whatsapp.InitiateVerificationRequest{
-		PhoneNumber:        req.PhoneNumber,
-		VerificationMethod: req.VerificationMethod,
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.SetPhoneNumberChannels(c, &api.SetPhoneNumberChannelsRequest{
+		Token:     token,
+		ContactId: req.ContactID,
+		Channels:  req.Channels,
+	})
+
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			c.JSON(http.StatusBadRequest, ApiResponse{
+				Success: false,
+				Message: status.Convert(err).Message(),
+			})
+		case codes.NotFound:
+			c.JSON(http.StatusNotFound, ApiResponse{
+				Success: false,
+				Message: "Phone number not found",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to update phone number channels",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    response.PhoneNumbers,
+	})
+}
+
+func (h *UserManagementHandlers) GetNotificationPreferencesHandler(c *gin.Context) {
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.GetNotificationPreferences(c, &api.GetNotificationPreferencesRequest{
+		Token: token,
 	})
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ApiResponse{
 			Success: false,
-			Message: "Failed to initiate WhatsApp verification",
+			Message: "Failed to get notification preferences",
 		})
 		return
 	}
 
-	expiresAt := time.Unix(response.ExpiresAt, 0)
-	attemptsRemaining := int(response.AttemptsRemaining)
-
 	c.JSON(http.StatusOK, ApiResponse{
-		Success:           response.Success,
-		VerificationToken: response.VerificationToken,
-		Message:           response.Message,
-		ExpiresAt:         &expiresAt,
-		AttemptsRemaining: &attemptsRemaining,
+		Success: true,
+		Data:    response,
 	})
 }
 
-func (h *UserManagementHandlers) VerifyWhatsAppHandler(c *gin.Context) {
-	var req WhatsAppVerificationRequest
+func (h *UserManagementHandlers) UpdateNotificationPreferencesHandler(c *gin.Context) {
+	var req UpdateNotificationPreferencesRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ApiResponse{
 			Success: false,
@@ -181,30 +421,83 @@ func (h *UserManagementHandlers) VerifyWhatsAppHandler(c *gin.Context) {
 		return
 	}
 
-	response, err := h.whatsappClient.VerifyCode(c, &whatsapp.VerifyCodeRequest{
-		Token: req.Token,
-		Code:  req.Code,
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.UpdateNotificationPreferences(c, &api.UpdateNotificationPreferencesRequest{
+		Token:    token,
+		Channels: req.Channels,
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ApiResponse{
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.FailedPrecondition:
+			c.JSON(http.StatusBadRequest, ApiResponse{
+				Success: false,
+				Message: status.Convert(err).Message(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to update notification preferences",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    response,
+	})
+}
+
+func (h *UserManagementHandlers) SetWhatsAppConsentHandler(c *gin.Context) {
+	var req SetWhatsAppConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ApiResponse{
 			Success: false,
-			Message: "Failed to verify WhatsApp code",
+			Message: "Invalid request format",
 		})
 		return
 	}
 
-	attemptsRemaining := int(response.AttemptsRemaining)
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.SetWhatsAppConsent(c, &api.SetWhatsAppConsentRequest{
+		Token:     token,
+		ContactId: req.ContactID,
+		Granted:   req.Granted,
+		ClientIp:  c.ClientIP(),
+	})
+
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.FailedPrecondition:
+			c.JSON(http.StatusBadRequest, ApiResponse{
+				Success: false,
+				Message: status.Convert(err).Message(),
+			})
+		case codes.NotFound:
+			c.JSON(http.StatusNotFound, ApiResponse{
+				Success: false,
+				Message: "Phone number not found",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to update WhatsApp consent",
+			})
+		}
+		return
+	}
 
 	c.JSON(http.StatusOK, ApiResponse{
-		Success:           response.Success,
-		Message:           response.Message,
-		AttemptsRemaining: &attemptsRemaining,
+		Success: true,
+		Data:    response.PhoneNumbers,
 	})
 }
 
-func (h *UserManagementHandlers) ResendWhatsAppCodeHandler(c *gin.Context) {
-	var req ResendVerificationRequest
+func (h *UserManagementHandlers) RequestPhoneVerificationHandler(c *gin.Context) {
+	var req RequestPhoneVerificationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ApiResponse{
 			Success: false,
@@ -213,32 +506,151 @@ func (h *UserManagementHandlers) ResendWhatsAppCodeHandler(c *gin.Context) {
 		return
 	}
 
-	response, err := h.whatsappClient.ResendCode(c, &whatsapp.ResendCodeRequest{
-		Token: req.Token,
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.RequestPhoneNumberVerification(c, &api.RequestPhoneNumberVerificationRequest{
+		Token:              token,
+		ContactId:          req.ContactID,
+		VerificationMethod: req.VerificationMethod,
+		ClientIp:           c.ClientIP(),
 	})
 
 	if err != nil {
+		if respondIfRateLimited(c, err) || respondIfPhoneRejected(c, err) {
+			return
+		}
+		if status.Code(err) == codes.NotFound {
+			c.JSON(http.StatusNotFound, ApiResponse{
+				Success: false,
+				Message: "Phone number not found",
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, ApiResponse{
 			Success: false,
-			Message: "Failed to resend WhatsApp code",
+			Message: "Failed to send verification code",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, VerificationPendingResponse{
+		Success:             response.Success,
+		Status:              "pending",
+		VerificationToken:   response.VerificationToken,
+		VerificationMethod:  response.VerificationMethod,
+		MaskedDestination:   response.MaskedPhoneNumber,
+		DeepLink:            response.DeepLink,
+		ExpiresAt:           time.Unix(response.ExpiresAt, 0),
+		ResendAvailableAt:   time.Unix(response.ResendAvailableAt, 0),
+		AttemptsRemaining:   int(response.AttemptsRemaining),
+		Message:             pendingVerificationMessage(response.ReverseInstructions),
+		Warning:             response.Warning,
+		ReverseInstructions: response.ReverseInstructions,
+	})
+}
+
+// StartPhoneReverificationHandler lets an admin ask all users with phone numbers older than
+// the instance's re-verification age to verify them again.
+func (h *UserManagementHandlers) StartPhoneReverificationHandler(c *gin.Context) {
+	var req StartPhoneReverificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ApiResponse{
+			Success: false,
+			Message: "Invalid request format",
 		})
 		return
 	}
 
-	expiresAt := time.Unix(response.ExpiresAt, 0)
-	attemptsRemaining := int(response.AttemptsRemaining)
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.StartPhoneReverification(c, &api.StartPhoneReverificationRequest{
+		Token:      token,
+		MaxAgeDays: int32(req.MaxAgeDays),
+	})
+
+	if err != nil {
+		switch status.Code(err) {
+		case codes.PermissionDenied:
+			c.JSON(http.StatusForbidden, ApiResponse{
+				Success: false,
+				Message: "Not permitted",
+			})
+		case codes.FailedPrecondition:
+			c.JSON(http.StatusBadRequest, ApiResponse{
+				Success: false,
+				Message: "Phone re-verification is not configured",
+			})
+		case codes.Aborted:
+			c.JSON(http.StatusConflict, ApiResponse{
+				Success: false,
+				Message: "Phone re-verification is already running",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to start phone re-verification",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponse{
+		Success: true,
+		Data: gin.H{
+			"phoneNumbersDue": response.PhoneNumbersDue,
+			"usersNotified":   response.UsersNotified,
+		},
+	})
+}
+
+// GetWhatsAppTemplateStatusHandler lists the WhatsApp templates the service sends with their
+// current review status.
+func (h *UserManagementHandlers) GetWhatsAppTemplateStatusHandler(c *gin.Context) {
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.GetWhatsAppTemplateStatus(c, &api.WhatsAppTemplateStatusRequest{
+		Token: token,
+	})
+
+	if err != nil {
+		switch status.Code(err) {
+		case codes.PermissionDenied:
+			c.JSON(http.StatusForbidden, ApiResponse{
+				Success: false,
+				Message: "Not permitted",
+			})
+		case codes.FailedPrecondition:
+			c.JSON(http.StatusBadRequest, ApiResponse{
+				Success: false,
+				Message: "WhatsApp business account is not configured",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to list WhatsApp templates",
+			})
+		}
+		return
+	}
+
+	templates := make([]gin.H, 0, len(response.Templates))
+	for _, template := range response.Templates {
+		templates = append(templates, gin.H{
+			"name":     template.Name,
+			"language": template.Language,
+			"status":   template.Status,
+			"usable":   template.Usable,
+		})
+	}
 
 	c.JSON(http.StatusOK, ApiResponse{
-		Success:           response.Success,
-		VerificationToken: response.VerificationToken,
-		Message:           response.Message,
-		ExpiresAt:         &expiresAt,
-		AttemptsRemaining: &attemptsRemaining,
+		Success: true,
+		Data:    gin.H{"templates": templates},
 	})
 }
 
-func (h *UserManagementHandlers) CancelWhatsAppVerificationHandler(c *gin.Context) {
-	var req CancelVerificationRequest
+func (h *UserManagementHandlers) RemovePhoneNumberHandler(c *gin.Context) {
+	var req RemovePhoneRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ApiResponse{
 			Success: false,
@@ -247,18 +659,82 @@ func (h *UserManagementHandlers) CancelWhatsAppVerificationHandler(c *gin.Contex
 		return
 	}
 
-	response, err := h.whatsappClient.CancelVerification(c, &whatsapp.CancelVerificationRequest{
-		Token: req.Token,
+	token := c.GetString(ContextKeyAccessToken)
+
+	response, err := h.userManagementClient.RemovePhoneNumber(c, &api.RemovePhoneNumberRequest{
+		Token:     token,
+		ContactId: req.ContactID,
+		Password:  req.Password,
+		ClientIp:  c.ClientIP(),
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ApiResponse{
+		if respondIfRateLimited(c, err) {
+			return
+		}
+		switch status.Code(err) {
+		case codes.PermissionDenied:
+			c.JSON(http.StatusForbidden, ApiResponse{
+				Success: false,
+				Message: "Re-authentication required",
+			})
+		case codes.NotFound:
+			c.JSON(http.StatusNotFound, ApiResponse{
+				Success: false,
+				Message: "Phone number not found",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to remove phone number",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponse{
+		Success: response.Success,
+		Message: response.Message,
+	})
+}
+
+// RevokePhoneChangeHandler undoes a phone change from the "this wasn't me" link of the
+// notification email. The link token authenticates the request.
+func (h *UserManagementHandlers) RevokePhoneChangeHandler(c *gin.Context) {
+	var req RevokePhoneChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ApiResponse{
 			Success: false,
-			Message: "Failed to cancel WhatsApp verification",
+			Message: "Invalid request format",
 		})
 		return
 	}
 
+	response, err := h.userManagementClient.RevokePhoneChange(c, &api.RevokePhoneChangeRequest{
+		Token: req.Token,
+	})
+
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			c.JSON(http.StatusBadRequest, ApiResponse{
+				Success: false,
+				Message: "Invalid or expired link",
+			})
+		case codes.FailedPrecondition:
+			c.JSON(http.StatusConflict, ApiResponse{
+				Success: false,
+				Message: "The phone change cannot be revoked anymore",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{
+				Success: false,
+				Message: "Failed to revoke phone change",
+			})
+		}
+		return
+	}
+
 	c.JSON(http.StatusOK, ApiResponse{
 		Success: response.Success,
 		Message: response.Message,
@@ -273,6 +749,7 @@ func (h *HttpEndpoints) verifyPhoneNumber(c *gin.Context) {
 			if err := h.JsonToProto(c, &req); err != nil {
 				return nil, status.Error(codes.InvalidArgument, err.Error())
 			}
+			req.AccessToken = c.GetString(ContextKeyAccessToken)
 			return h.clients.UserManagement.VerifyPhoneNumber(context.Background(), &req)
 		},
 	)
@@ -286,6 +763,8 @@ func (h *HttpEndpoints) resendPhoneVerificationCode(c *gin.Context) {
 			if err := h.JsonToProto(c, &req); err != nil {
 				return nil, status.Error(codes.InvalidArgument, err.Error())
 			}
+			req.AccessToken = c.GetString(ContextKeyAccessToken)
+			req.ClientIp = c.ClientIP()
 			return h.clients.UserManagement.ResendVerificationCode(context.Background(), &req)
 		},
 	)
@@ -299,7 +778,182 @@ func (h *HttpEndpoints) cancelPhoneVerification(c *gin.Context) {
 			if err := h.JsonToProto(c, &req); err != nil {
 				return nil, status.Error(codes.InvalidArgument, err.Error())
 			}
+			req.AccessToken = c.GetString(ContextKeyAccessToken)
 			return h.clients.UserManagement.CancelVerification(context.Background(), &req)
 		},
 	)
-}
\ No newline at end of file
+}
+
+func (h *HttpEndpoints) requestPhoneLoginCode(c *gin.Context) {
+	h.grpcCallHandler(
+		c,
+		func(c *gin.Context) (protoreflect.ProtoMessage, error) {
+			var req umAPI.PhoneLoginCodeRequest
+			if err := h.JsonToProto(c, &req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			req.ClientIp = c.ClientIP()
+			return h.clients.UserManagement.RequestPhoneLoginCode(context.Background(), &req)
+		},
+	)
+}
+
+func (h *HttpEndpoints) loginWithPhoneCode(c *gin.Context) {
+	h.grpcCallHandler(
+		c,
+		func(c *gin.Context) (protoreflect.ProtoMessage, error) {
+			var req umAPI.PhoneLoginRequest
+			if err := h.JsonToProto(c, &req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return h.clients.UserManagement.LoginWithPhoneCode(context.Background(), &req)
+		},
+	)
+}
+
+func (h *HttpEndpoints) enablePhoneSecondFactor(c *gin.Context) {
+	h.grpcCallHandler(
+		c,
+		func(c *gin.Context) (protoreflect.ProtoMessage, error) {
+			var req umAPI.EnablePhoneSecondFactorRequest
+			if err := h.JsonToProto(c, &req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			req.Token = c.GetString(ContextKeyAccessToken)
+			return h.clients.UserManagement.EnablePhoneSecondFactor(context.Background(), &req)
+		},
+	)
+}
+
+func (h *HttpEndpoints) disablePhoneSecondFactor(c *gin.Context) {
+	h.grpcCallHandler(
+		c,
+		func(c *gin.Context) (protoreflect.ProtoMessage, error) {
+			var req umAPI.DisablePhoneSecondFactorRequest
+			if err := h.JsonToProto(c, &req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			req.Token = c.GetString(ContextKeyAccessToken)
+			return h.clients.UserManagement.DisablePhoneSecondFactor(context.Background(), &req)
+		},
+	)
+}
+
+func (h *HttpEndpoints) regenerateRecoveryCodes(c *gin.Context) {
+	h.grpcCallHandler(
+		c,
+		func(c *gin.Context) (protoreflect.ProtoMessage, error) {
+			var req umAPI.RegenerateRecoveryCodesRequest
+			if err := h.JsonToProto(c, &req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			req.Token = c.GetString(ContextKeyAccessToken)
+			return h.clients.UserManagement.RegenerateRecoveryCodes(context.Background(), &req)
+		},
+	)
+}
+
+func (h *HttpEndpoints) verifySecondFactor(c *gin.Context) {
+	h.grpcCallHandler(
+		c,
+		func(c *gin.Context) (protoreflect.ProtoMessage, error) {
+			var req umAPI.VerifySecondFactorRequest
+			if err := h.JsonToProto(c, &req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return h.clients.UserManagement.VerifySecondFactor(context.Background(), &req)
+		},
+	)
+}
+
+// startReverseVerification returns the message to send to the WhatsApp business number for the
+// pending verification of the email fallback link.
+func (h *HttpEndpoints) startReverseVerification(c *gin.Context) {
+	h.grpcCallHandler(
+		c,
+		func(c *gin.Context) (protoreflect.ProtoMessage, error) {
+			var req umAPI.StartReverseVerificationRequest
+			if err := h.JsonToProto(c, &req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return h.clients.UserManagement.StartReverseVerification(context.Background(), &req)
+		},
+	)
+}
+
+// telegramWebhook forwards Telegram Bot API updates to the user management service. Telegram
+// authenticates with the secret token set when registering the webhook.
+func (h *HttpEndpoints) telegramWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ApiResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	_, err = h.clients.UserManagement.HandleTelegramUpdate(context.Background(), &umAPI.TelegramUpdateRequest{
+		SecretToken: c.GetHeader("X-Telegram-Bot-Api-Secret-Token"),
+		Update:      body,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.PermissionDenied:
+			c.JSON(http.StatusUnauthorized, ApiResponse{Success: false})
+		case codes.InvalidArgument:
+			// Telegram would deliver an unreadable update again and again
+			c.JSON(http.StatusOK, ApiResponse{Success: false})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{Success: false})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, ApiResponse{Success: true})
+}
+
+// whatsappWebhookVerify answers the subscription check Meta runs when the webhook is
+// registered, echoing hub.challenge as plain text.
+func (h *HttpEndpoints) whatsappWebhookVerify(c *gin.Context) {
+	resp, err := h.clients.UserManagement.VerifyWhatsAppWebhook(context.Background(), &umAPI.WhatsAppWebhookChallengeRequest{
+		Mode:        c.Query("hub.mode"),
+		VerifyToken: c.Query("hub.verify_token"),
+		Challenge:   c.Query("hub.challenge"),
+	})
+	if err != nil {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	c.String(http.StatusOK, resp.Challenge)
+}
+
+// whatsappWebhook forwards WhatsApp Cloud API notifications to the user management service,
+// with the X-Hub-Signature-256 header authenticating the raw body.
+func (h *HttpEndpoints) whatsappWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ApiResponse{
+			Success: false,
+			Message: "Invalid request format",
+		})
+		return
+	}
+
+	_, err = h.clients.UserManagement.HandleWhatsAppWebhook(context.Background(), &umAPI.WhatsAppWebhookRequest{
+		Signature: c.GetHeader("X-Hub-Signature-256"),
+		Payload:   body,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.PermissionDenied:
+			c.JSON(http.StatusUnauthorized, ApiResponse{Success: false})
+		case codes.InvalidArgument:
+			// Meta would keep retrying an unreadable notification
+			c.JSON(http.StatusOK, ApiResponse{Success: false})
+		default:
+			c.JSON(http.StatusInternalServerError, ApiResponse{Success: false})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, ApiResponse{Success: true})
+}
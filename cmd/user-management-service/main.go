@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/influenzanet/user-management-service/internal/config"
+	"github.com/influenzanet/user-management-service/pkg/dbs/globaldb"
+	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
+	gc "github.com/influenzanet/user-management-service/pkg/grpc/clients"
+	"github.com/influenzanet/user-management-service/pkg/grpc/service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/timer_event"
+)
+
+func main() {
+	conf := config.InitConfig()
+
+	clients := &models.APIClients{}
+
+	messagingClient, messagingServiceClose := gc.ConnectToMessagingService(conf.ServiceURLs.MessagingService)
+	defer messagingServiceClose()
+	clients.MessagingService = messagingClient
+
+	loggingClient, loggingServiceClose := gc.ConnectToLoggingService(conf.ServiceURLs.LoggingService)
+	defer loggingServiceClose()
+	clients.LoggingService = loggingClient
+
+	userDBService := userdb.NewUserDBService(conf.UserDBConfig)
+	globalDBService := globaldb.NewGlobalDBService(conf.GlobalDBConfig)
+
+	// Phone features: secrets provider, phone number indexes, JWT key and WhatsApp templates
+	instances, err := globalDBService.GetAllInstances()
+	if err != nil {
+		log.Fatalf("Loading instances: %v", err)
+	}
+	instanceIDs := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		instanceIDs = append(instanceIDs, instance.InstanceID)
+	}
+	if err := service.StartPhoneServices(userDBService, instanceIDs); err != nil {
+		log.Fatalf("Starting phone services: %v", err)
+	}
+
+	// Start timer thread
+	userTimerService := timer_event.NewUserManagmentTimerService(
+		conf.Intervals.TimerEventFrequency,
+		globalDBService,
+		userDBService,
+		clients,
+		conf.CleanUpUnverifiedUsersAfter,
+		conf.ReminderToUnverifiedAccountsAfter,
+		conf.NotifyInactiveUsersAfter,
+		conf.DeleteAccountAfterNotifyingUser,
+	)
+	userTimerService.Run()
+
+	// Start server thread
+	ctx := context.Background()
+
+	if err := service.RunServer(
+		ctx,
+		conf.Port,
+		clients,
+		userDBService,
+		globalDBService,
+		conf.Intervals,
+		conf.NewUserCountLimit,
+	); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package userdb
+
+import (
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetMessagingConsents returns the consent records of the user, revoked ones included.
+func (dbService *UserDBService) GetMessagingConsents(instanceID string, userID string) ([]models.MessagingConsent, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		MessagingConsents []models.MessagingConsent `bson:"messagingConsents"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"messagingConsents": 1})
+	err = dbService.collectionRefUsers(instanceID).FindOne(ctx, bson.M{"_id": _userID}, opts).Decode(&result)
+	return result.MessagingConsents, err
+}
+
+// AddMessagingConsent records a new consent of the user, unless one is already active for the
+// same channel and phone number.
+func (dbService *UserDBService) AddMessagingConsent(instanceID string, userID string, consent models.MessagingConsent) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id": _userID,
+		"messagingConsents": bson.M{
+			"$not": bson.M{"$elemMatch": bson.M{
+				"channel":     consent.Channel,
+				"phoneNumber": consent.PhoneNumber,
+				"revokedAt":   0,
+			}},
+		},
+	}
+	update := bson.M{
+		"$push": bson.M{"messagingConsents": consent},
+		"$set":  bson.M{"timestamps.updatedAt": time.Now().Unix()},
+	}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	return err
+}
+
+// RevokeMessagingConsent revokes the active consents of the user for the channel and phone
+// number. It returns false if none was active.
+func (dbService *UserDBService) RevokeMessagingConsent(instanceID string, userID string, channel string, phoneNumber string, source string) (bool, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().Unix()
+	filter := bson.M{
+		"_id": _userID,
+		"messagingConsents": bson.M{
+			"$elemMatch": bson.M{"channel": channel, "phoneNumber": phoneNumber, "revokedAt": 0},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"messagingConsents.$[active].revokedAt":     now,
+			"messagingConsents.$[active].revokedSource": source,
+			"timestamps.updatedAt":                      now,
+		},
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{
+			bson.M{"active.channel": channel, "active.phoneNumber": phoneNumber, "active.revokedAt": 0},
+		},
+	})
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
@@ -0,0 +1,58 @@
+package userdb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetNotificationPreferences returns the notification channel preferences of the user, empty
+// ones if none were ever set.
+func (dbService *UserDBService) GetNotificationPreferences(instanceID string, userID string) (models.NotificationPreferences, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return models.NotificationPreferences{}, err
+	}
+
+	var result struct {
+		NotificationPreferences models.NotificationPreferences `bson:"notificationPreferences"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"notificationPreferences": 1})
+	err = dbService.collectionRefUsers(instanceID).FindOne(ctx, bson.M{"_id": _userID}, opts).Decode(&result)
+	return result.NotificationPreferences, err
+}
+
+// SetNotificationPreferences replaces the notification channel preferences of the user.
+func (dbService *UserDBService) SetNotificationPreferences(instanceID string, userID string, preferences models.NotificationPreferences) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	preferences.UpdatedAt = now
+	update := bson.M{
+		"$set": bson.M{
+			"notificationPreferences": preferences,
+			"timestamps.updatedAt":    now,
+		},
+	}
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, bson.M{"_id": _userID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("user not found")
+	}
+	return nil
+}
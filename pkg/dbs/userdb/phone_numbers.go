@@ -0,0 +1,548 @@
+package userdb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateIndexForPhoneNumbers creates the index used to look up users by their (E.164 normalized)
+// phone contact.
+func (dbService *UserDBService) CreateIndexForPhoneNumbers(instanceID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_, err := dbService.collectionRefUsers(instanceID).Indexes().CreateOne(
+		ctx,
+		mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "contactInfos.phone", Value: 1},
+			},
+			Options: options.Index().SetSparse(true),
+		},
+	)
+	return err
+}
+
+// FindUserIDsByPhoneNumber returns the IDs of all users having phoneNumber as confirmed phone
+// contact. Unverified contacts do not prove anyone owns the number, so they are not returned.
+func (dbService *UserDBService) FindUserIDsByPhoneNumber(instanceID string, phoneNumber string) ([]string, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	filter := bson.M{
+		"contactInfos": bson.M{
+			"$elemMatch": bson.M{"type": "phone", "phone": phoneNumber, "confirmedAt": bson.M{"$gt": 0}},
+		},
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+
+	cur, err := dbService.collectionRefUsers(instanceID).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	userIDs := []string{}
+	for cur.Next(ctx) {
+		var result struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, result.ID.Hex())
+	}
+	return userIDs, cur.Err()
+}
+
+// ReplacePhoneNumber replaces the phone of the contact contactID, as long as it still is
+// oldPhoneNumber, with newPhoneNumber confirmed now over verifiedVia, and records the old number
+// in the user's phone number history. All changes are applied in a single update.
+func (dbService *UserDBService) ReplacePhoneNumber(instanceID string, userID string, contactID string, oldPhoneNumber string, newPhoneNumber string, verifiedVia string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	_contactID, err := primitive.ObjectIDFromHex(contactID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	filter := bson.M{
+		"_id": _userID,
+		"contactInfos": bson.M{
+			"$elemMatch": bson.M{"_id": _contactID, "type": "phone", "phone": oldPhoneNumber},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"contactInfos.$.phone":          newPhoneNumber,
+			"contactInfos.$.confirmedAt":    now,
+			"contactInfos.$.verifiedVia":    verifiedVia,
+			"contactInfos.$.lastVerifiedAt": now,
+			"timestamps.updatedAt":          now,
+		},
+		"$push": bson.M{
+			"phoneNumberHistory": models.PhoneNumberHistoryEntry{
+				ContactID:  _contactID,
+				Phone:      oldPhoneNumber,
+				ReplacedAt: now,
+			},
+		},
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("phone contact not found or changed concurrently")
+	}
+	return nil
+}
+
+// RevertPhoneNumber sets the phone contact contact.ID back to contact (number, verification
+// and channels), as long as its number still is currentPhoneNumber.
+func (dbService *UserDBService) RevertPhoneNumber(instanceID string, userID string, currentPhoneNumber string, contact models.ContactInfo) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id": _userID,
+		"contactInfos": bson.M{
+			"$elemMatch": bson.M{"_id": contact.ID, "type": "phone", "phone": currentPhoneNumber},
+		},
+	}
+	set := bson.M{
+		"contactInfos.$.phone":          contact.Phone,
+		"contactInfos.$.confirmedAt":    contact.ConfirmedAt,
+		"contactInfos.$.verifiedVia":    contact.VerifiedVia,
+		"contactInfos.$.lastVerifiedAt": contact.LastVerifiedAt,
+		"timestamps.updatedAt":          time.Now().Unix(),
+	}
+	update := bson.M{"$set": set}
+	if len(contact.Channels) > 0 {
+		set["contactInfos.$.channels"] = contact.Channels
+	} else {
+		update["$unset"] = bson.M{"contactInfos.$.channels": ""}
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("phone contact not found or changed concurrently")
+	}
+	return nil
+}
+
+// RemovePhoneNumber removes the phone contact contactID from the user and from the contacts
+// messages are sent to.
+func (dbService *UserDBService) RemovePhoneNumber(instanceID string, userID string, contactID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	_contactID, err := primitive.ObjectIDFromHex(contactID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": _userID}
+	update := bson.M{
+		"$pull": bson.M{
+			"contactInfos":                        bson.M{"_id": _contactID, "type": "phone"},
+			"contactPreferences.sendNewsletterTo": contactID,
+		},
+		"$set": bson.M{"timestamps.updatedAt": time.Now().Unix()},
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount < 1 {
+		return errors.New("phone contact not found")
+	}
+	return nil
+}
+
+// RestorePhoneNumber adds a removed phone contact back to the user, keeping its ID and
+// verification. It fails if the user has a contact with the same ID or number again.
+func (dbService *UserDBService) RestorePhoneNumber(instanceID string, userID string, contact models.ContactInfo) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	contact.Type = "phone"
+	contact.Primary = false
+	filter := bson.M{
+		"_id":                _userID,
+		"contactInfos._id":   bson.M{"$ne": contact.ID},
+		"contactInfos.phone": bson.M{"$ne": contact.Phone},
+	}
+	update := bson.M{
+		"$push": bson.M{"contactInfos": contact},
+		"$set":  bson.M{"timestamps.updatedAt": time.Now().Unix()},
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("user not found or phone contact already restored")
+	}
+	return nil
+}
+
+// SetPrimaryPhoneNumber marks the phone contact contactID as primary and all other phone
+// contacts of the user as not primary.
+func (dbService *UserDBService) SetPrimaryPhoneNumber(instanceID string, userID string, contactID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	_contactID, err := primitive.ObjectIDFromHex(contactID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":          _userID,
+		"contactInfos": bson.M{"$elemMatch": bson.M{"_id": _contactID, "type": "phone"}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"contactInfos.$[other].primary":  false,
+			"contactInfos.$[chosen].primary": true,
+			"timestamps.updatedAt":           time.Now().Unix(),
+		},
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{
+			bson.M{"other.type": "phone", "other._id": bson.M{"$ne": _contactID}},
+			bson.M{"chosen._id": _contactID},
+		},
+	})
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("phone contact not found")
+	}
+	return nil
+}
+
+// SetPhoneNumberChannels sets the channels (whatsapp, sms, voice) the phone contact contactID
+// may be used for.
+func (dbService *UserDBService) SetPhoneNumberChannels(instanceID string, userID string, contactID string, channels []string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	_contactID, err := primitive.ObjectIDFromHex(contactID)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":          _userID,
+		"contactInfos": bson.M{"$elemMatch": bson.M{"_id": _contactID, "type": "phone"}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"contactInfos.$.channels": channels,
+			"timestamps.updatedAt":    time.Now().Unix(),
+		},
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("phone contact not found")
+	}
+	return nil
+}
+
+// AddUnverifiedPhoneNumber adds phoneNumber as a new, not yet confirmed phone contact of the user
+// and returns the ID of the contact.
+func (dbService *UserDBService) AddUnverifiedPhoneNumber(instanceID string, userID string, phoneNumber string) (string, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", err
+	}
+
+	contact := models.ContactInfo{
+		ID:    primitive.NewObjectID(),
+		Type:  "phone",
+		Phone: phoneNumber,
+	}
+	filter := bson.M{
+		"_id":                _userID,
+		"contactInfos.phone": bson.M{"$ne": phoneNumber},
+	}
+	update := bson.M{
+		"$push": bson.M{"contactInfos": contact},
+		"$set":  bson.M{"timestamps.updatedAt": time.Now().Unix()},
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return "", err
+	}
+	if res.MatchedCount < 1 {
+		return "", errors.New("user not found or phone number already added")
+	}
+	return contact.ID.Hex(), nil
+}
+
+// AddVerifiedPhoneNumber adds phoneNumber as a new phone contact of the user, confirmed now over
+// verifiedVia, and returns the ID of the contact.
+func (dbService *UserDBService) AddVerifiedPhoneNumber(instanceID string, userID string, phoneNumber string, verifiedVia string) (string, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	contact := models.ContactInfo{
+		ID:             primitive.NewObjectID(),
+		Type:           "phone",
+		Phone:          phoneNumber,
+		ConfirmedAt:    now,
+		VerifiedVia:    verifiedVia,
+		LastVerifiedAt: now,
+	}
+	filter := bson.M{
+		"_id":                _userID,
+		"contactInfos.phone": bson.M{"$ne": phoneNumber},
+	}
+	update := bson.M{
+		"$push": bson.M{"contactInfos": contact},
+		"$set":  bson.M{"timestamps.updatedAt": now},
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return "", err
+	}
+	if res.MatchedCount < 1 {
+		return "", errors.New("user not found or phone number already added")
+	}
+	return contact.ID.Hex(), nil
+}
+
+// ConfirmPhoneNumber records a successful verification of the phone contact contactID over
+// verifiedVia (whatsapp, sms, voice). ConfirmedAt is only set by the first verification,
+// LastVerifiedAt by every one.
+func (dbService *UserDBService) ConfirmPhoneNumber(instanceID string, userID string, contactID string, verifiedVia string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	_contactID, err := primitive.ObjectIDFromHex(contactID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	filter := bson.M{
+		"_id":          _userID,
+		"contactInfos": bson.M{"$elemMatch": bson.M{"_id": _contactID, "type": "phone"}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"contactInfos.$[verified].verifiedVia":    verifiedVia,
+			"contactInfos.$[verified].lastVerifiedAt": now,
+			"contactInfos.$[first].confirmedAt":       now,
+			"timestamps.updatedAt":                    now,
+		},
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{
+			bson.M{"verified._id": _contactID},
+			bson.M{"first._id": _contactID, "first.confirmedAt": bson.M{"$not": bson.M{"$gt": 0}}},
+		},
+	})
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("phone contact not found")
+	}
+	return nil
+}
+
+// FindUsersWithPhoneVerifiedBefore returns the users having a confirmed phone contact whose last
+// verification (or confirmation, if it was never re-verified) is older than verifiedBefore.
+func (dbService *UserDBService) FindUsersWithPhoneVerifiedBefore(instanceID string, verifiedBefore int64) ([]models.User, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	filter := bson.M{
+		"contactInfos": bson.M{
+			"$elemMatch": bson.M{
+				"type":        "phone",
+				"confirmedAt": bson.M{"$gt": 0},
+				"$or": bson.A{
+					bson.M{"lastVerifiedAt": bson.M{"$lt": verifiedBefore}},
+					bson.M{"lastVerifiedAt": bson.M{"$exists": false}, "confirmedAt": bson.M{"$lt": verifiedBefore}},
+				},
+			},
+		},
+	}
+
+	cur, err := dbService.collectionRefUsers(instanceID).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	users := []models.User{}
+	for cur.Next(ctx) {
+		var user models.User
+		if err := cur.Decode(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, cur.Err()
+}
+
+// GetPhoneSecondFactor returns the phone second factor settings of the user, disabled ones if
+// none were ever set.
+func (dbService *UserDBService) GetPhoneSecondFactor(instanceID string, userID string) (models.PhoneSecondFactor, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return models.PhoneSecondFactor{}, err
+	}
+
+	var result struct {
+		PhoneSecondFactor models.PhoneSecondFactor `bson:"phoneSecondFactor"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"phoneSecondFactor": 1})
+	err = dbService.collectionRefUsers(instanceID).FindOne(ctx, bson.M{"_id": _userID}, opts).Decode(&result)
+	return result.PhoneSecondFactor, err
+}
+
+// SetPhoneSecondFactor stores the phone second factor settings of the user, replacing previous
+// ones including their recovery codes.
+func (dbService *UserDBService) SetPhoneSecondFactor(instanceID string, userID string, secondFactor models.PhoneSecondFactor) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"phoneSecondFactor":    secondFactor,
+			"timestamps.updatedAt": time.Now().Unix(),
+		},
+	}
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, bson.M{"_id": _userID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount < 1 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// RemovePhoneSecondFactor disables the phone second factor of the user.
+func (dbService *UserDBService) RemovePhoneSecondFactor(instanceID string, userID string) error {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$unset": bson.M{"phoneSecondFactor": ""},
+		"$set":   bson.M{"timestamps.updatedAt": time.Now().Unix()},
+	}
+	_, err = dbService.collectionRefUsers(instanceID).UpdateOne(ctx, bson.M{"_id": _userID}, update)
+	return err
+}
+
+// UsePhoneSecondFactorRecoveryCode marks the unused recovery code with the given hash as used.
+// It returns false if the user has no such unused code.
+func (dbService *UserDBService) UsePhoneSecondFactorRecoveryCode(instanceID string, userID string, codeHash string) (bool, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	_userID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.M{
+		"_id":                       _userID,
+		"phoneSecondFactor.enabled": true,
+		"phoneSecondFactor.recoveryCodes": bson.M{
+			"$elemMatch": bson.M{"hash": codeHash, "usedAt": 0},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"phoneSecondFactor.recoveryCodes.$.usedAt": time.Now().Unix()},
+	}
+
+	res, err := dbService.collectionRefUsers(instanceID).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
@@ -0,0 +1,49 @@
+package userdb
+
+import (
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindUsersForWeeklyReminder returns up to limit confirmed users subscribed to weekly messages
+// on weekday (0 is Sunday) that have a confirmed phone contact, in _id order, starting after
+// the user afterID (from the first user if empty).
+func (dbService *UserDBService) FindUsersForWeeklyReminder(instanceID string, weekday int32, afterID string, limit int64) ([]models.User, error) {
+	ctx, cancel := dbService.getContext()
+	defer cancel()
+
+	filter := bson.M{
+		"account.accountConfirmedAt":                       bson.M{"$gt": 0},
+		"contactPreferences.subscribedToWeekly":            true,
+		"contactPreferences.receiveWeeklyMessageDayOfWeek": weekday,
+		"contactInfos": bson.M{
+			"$elemMatch": bson.M{"type": "phone", "confirmedAt": bson.M{"$gt": 0}},
+		},
+	}
+	if afterID != "" {
+		_afterID, err := primitive.ObjectIDFromHex(afterID)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = bson.M{"$gt": _afterID}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+
+	cur, err := dbService.collectionRefUsers(instanceID).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	users := []models.User{}
+	for cur.Next(ctx) {
+		var user models.User
+		if err := cur.Decode(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, cur.Err()
+}
@@ -3,39 +3,116 @@ package service
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
+	"strings"
 	"time"
-	"regexp"
 
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/api"
 	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/phone"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 const (
-	MAX_VERIFICATION_ATTEMPTS = 3
+	MAX_VERIFICATION_ATTEMPTS        = 3
 	VERIFICATION_CODE_EXPIRY_MINUTES = 10
-	MAX_RETRY_ATTEMPTS = 3
+	MAX_RETRY_ATTEMPTS               = 3
+	MAX_RESENDS_PER_VERIFICATION     = 3
+	// Extra code guesses granted by a resend: guesses are not reset, so a client cannot get
+	// unlimited tries by resending repeatedly.
+	VERIFICATION_ATTEMPTS_PER_RESEND = 1
+)
+
+// Minimum delay before the next resend, indexed by the number of resends already done.
+// The last value applies to any further resend.
+var resendCooldowns = []time.Duration{30 * time.Second, 60 * time.Second, 5 * time.Minute}
+
+// Channels a verification code can be sent over. SMS is not offered until an SMS provider
+// sends the codes.
+var verificationMethods = []string{PHONE_CHANNEL_WHATSAPP, PHONE_CHANNEL_VOICE, VERIFICATION_METHOD_TELEGRAM, VERIFICATION_METHOD_WHATSAPP_REVERSE}
+
+// Returned for codes sent by SMS: no SMS provider sends them yet, and reporting success would
+// leave the user waiting for a code that never comes
+var errSMSUnavailable = errors.New("no SMS provider configured")
+
+// Maximum age of an access token for it to count as fresh re-authentication
+const REAUTH_MAX_AGE = 5 * time.Minute
+
+// Security log events of phone contact changes
+const (
+	LOG_EVENT_PHONE_REMOVED = "PHONE_REMOVED"
+)
+
+// What a successful verification does with the phone number
+const (
+	VERIFICATION_PURPOSE_ADD_PHONE    = "add"
+	VERIFICATION_PURPOSE_CHANGE_PHONE = "change"
+	// Confirms an existing, unverified or due for re-verification, phone contact
+	VERIFICATION_PURPOSE_VERIFY_PHONE = "verify"
+	// Exchanged for login tokens by LoginWithPhoneCode, never by VerifyPhoneNumber
+	VERIFICATION_PURPOSE_LOGIN = "login"
+	// Second factor of a password login, completed by VerifySecondFactor
+	VERIFICATION_PURPOSE_SECOND_FACTOR = "2fa"
 )
 
 type VerificationAttempt struct {
-	PhoneNumber    string
-	Code           string
-	Token          string
-	Attempts       int
-	MaxAttempts    int
-	CreatedAt      time.Time
-	ExpiresAt      time.Time
-	Status         string // "pending", "verified", "expired", "failed"
-	RetryCount     int
-	MaxRetries     int
+	UserID      string
+	InstanceID  string
+	Purpose     string
+	PhoneNumber string
+	// Phone contact replaced by PhoneNumber, for VERIFICATION_PURPOSE_CHANGE_PHONE
+	ReplacesContactID   string
+	ReplacesPhoneNumber string
+	// Existing phone contact confirmed by the attempt, for VERIFICATION_PURPOSE_VERIFY_PHONE
+	ContactID string
+	// Set while the code sent to the replaced number (or to ProofEmail) is awaited, before the
+	// new number gets a code
+	AwaitingOldNumberProof bool
+	OldNumberProofMethod   string // "phone", "email"
+	ProofEmail             string
+	ProofEmailLanguage     string
+	Code                   string
+	Token                  string
+	Method                 string // "whatsapp", "sms", "voice", "telegram", "email-link"
+	Language               string // spoken language of voice calls
+	// Telegram deep link code, chat that opened it and whether the number shared there matched
+	TelegramLinkCode       string
+	TelegramChatID         int64
+	TelegramPhoneConfirmed bool
+	// Email fallback link token and address, and whether the user opened the link and is
+	// expected to send ReverseCode to the business number
+	FallbackToken          string
+	FallbackEmail          string
+	FallbackLanguage       string
+	AwaitingReverseMessage bool
+	ReverseCode            string // shown on the website only, never sent to the user
+	WhatsAppOptIn          bool   // given when starting the verification, recorded once verified
+	PhoneWarning           string // reason of a phoneUniqueness "warn" match, reported to the client
+	ClientIP               string
+	Attempts               int
+	MaxAttempts            int
+	CreatedAt              time.Time
+	ExpiresAt              time.Time
+	ResendAvailableAt      time.Time
+	ResendCount            int
+	Status                 string // "pending", "verified", "expired", "failed"
+	RetryCount             int
+	MaxRetries             int
 }
 
-// In-memory storage for verification attempts (in production, use Redis or database)
-var verificationAttempts = make(map[string]*VerificationAttempt)
+// isLoginChallenge reports whether the attempt authenticates a login instead of confirming a
+// phone number. Such attempts are completed by their own endpoints only.
+func (attempt *VerificationAttempt) isLoginChallenge() bool {
+	return attempt.Purpose == VERIFICATION_PURPOSE_LOGIN || attempt.Purpose == VERIFICATION_PURPOSE_SECOND_FACTOR
+}
 
 func (s *userManagementServer) AddPhoneNumber(ctx context.Context, req *api.AddPhoneNumberRequest) (*api.AddPhoneNumberResponse, error) {
 	if req == nil || req.Token == "" {
@@ -51,9 +128,14 @@ func (s *userManagementServer) AddPhoneNumber(ctx context.Context, req *api.AddP
 		return nil, status.Error(codes.InvalidArgument, "phone number cannot be empty")
 	}
 
-	// Validate phone number format
-	if !s.isValidPhoneNumber(req.PhoneNumber) {
-		return nil, status.Error(codes.InvalidArgument, "phone not valid")
+	// A number stored without verification is checked against the general number types
+	method := req.VerificationMethod
+	if req.SkipVerification {
+		method = ""
+	}
+	phoneNumber, err := s.validatePhoneNumber(instanceID, req.PhoneNumber, method)
+	if err != nil {
+		return nil, err
 	}
 
 	user, err := s.userDBservice.GetUser(instanceID, userID)
@@ -65,46 +147,41 @@ func (s *userManagementServer) AddPhoneNumber(ctx context.Context, req *api.AddP
 		return nil, status.Error(codes.FailedPrecondition, "account not confirmed")
 	}
 
-	verificationToken, err := s.generateVerificationToken()
-	if err != nil {
-		log.Printf("Error generating verification token: %v", err)
-		return nil, status.Error(codes.Internal, "failed to generate verification token")
+	if findPhoneContactByNumber(user, phoneNumber.E164()) != nil {
+		return nil, status.Error(codes.AlreadyExists, "phone number already added")
 	}
-
-	verificationCode := s.generateVerificationCode()
-
-	// Create verification attempt
-	attempt := &VerificationAttempt{
-		PhoneNumber: req.PhoneNumber,
-		Code:        verificationCode,
-		Token:       verificationToken,
-		Attempts:    0,
-		MaxAttempts: MAX_VERIFICATION_ATTEMPTS,
-		CreatedAt:   time.Now(),
-		ExpiresAt:   time.Now().Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute),
-		Status:      "pending",
-		RetryCount:  0,
-		MaxRetries:  MAX_RETRY_ATTEMPTS,
+	if len(phoneContacts(user)) >= MAX_PHONE_NUMBERS_PER_USER {
+		return nil, status.Error(codes.FailedPrecondition, "maximum number of phone numbers reached")
 	}
 
-	verificationAttempts[verificationToken] = attempt
-
-	// Send verification code based on method
-	verificationMethod := req.VerificationMethod
-	if verificationMethod == "" {
-		verificationMethod = "whatsapp" // default
+	if req.SkipVerification {
+		return s.addUnverifiedPhoneNumber(instanceID, userID, phoneNumber.E164())
 	}
 
-	err = s.sendVerificationCode(req.PhoneNumber, verificationCode, verificationMethod, 0)
+	attempt, err := s.startPhoneVerification(&VerificationAttempt{
+		UserID:        userID,
+		InstanceID:    instanceID,
+		Purpose:       VERIFICATION_PURPOSE_ADD_PHONE,
+		PhoneNumber:   phoneNumber.E164(),
+		Method:        req.VerificationMethod,
+		Language:      user.Account.PreferredLanguage,
+		WhatsAppOptIn: req.WhatsappOptIn,
+	}, req.ClientIp)
 	if err != nil {
-		log.Printf("Error sending verification: %v", err)
-		delete(verificationAttempts, verificationToken)
-		return nil, status.Error(codes.Internal, "failed to send verification code")
+		return nil, err
 	}
 
 	return &api.AddPhoneNumberResponse{
-		Success:           true,
-		VerificationToken: verificationToken,
+		Success:             true,
+		VerificationToken:   attempt.Token,
+		VerificationMethod:  attempt.Method,
+		MaskedPhoneNumber:   attempt.maskedDestination(),
+		ExpiresAt:           attempt.ExpiresAt.Unix(),
+		ResendAvailableAt:   attempt.ResendAvailableAt.Unix(),
+		AttemptsRemaining:   int32(attempt.MaxAttempts - attempt.Attempts),
+		DeepLink:            attempt.deepLink(),
+		Warning:             attempt.PhoneWarning,
+		ReverseInstructions: attempt.reverseInstructions(),
 	}, nil
 }
 
@@ -122,9 +199,9 @@ func (s *userManagementServer) EditPhoneNumber(ctx context.Context, req *api.Edi
 		return nil, status.Error(codes.InvalidArgument, "new phone number cannot be empty")
 	}
 
-	// Validate phone number format
-	if !s.isValidPhoneNumber(req.NewPhoneNumber) {
-		return nil, status.Error(codes.InvalidArgument, "phone not valid")
+	phoneNumber, err := s.validatePhoneNumber(instanceID, req.NewPhoneNumber, req.VerificationMethod)
+	if err != nil {
+		return nil, err
 	}
 
 	user, err := s.userDBservice.GetUser(instanceID, userID)
@@ -136,17 +213,81 @@ func (s *userManagementServer) EditPhoneNumber(ctx context.Context, req *api.Edi
 		return nil, status.Error(codes.FailedPrecondition, "account not confirmed")
 	}
 
-	// Check if user has a phone number to edit
-	hasPhone := false
-	for _, contact := range user.ContactInfos {
-		if contact.Type == "phone" {
-			hasPhone = true
-			break
+	// Phone number to edit: the requested one, or the primary one by default
+	var currentPhone *models.ContactInfo
+	if req.ContactId != "" {
+		currentPhone = findPhoneContact(user, req.ContactId)
+	} else {
+		currentPhone = primaryPhoneContact(user)
+		if phones := phoneContacts(user); currentPhone == nil && len(phones) > 0 {
+			currentPhone = &phones[0]
 		}
 	}
-	if !hasPhone {
+	if currentPhone == nil {
 		return nil, status.Error(codes.InvalidArgument, "user has no phone number to edit")
 	}
+	if currentPhone.Phone == phoneNumber.E164() {
+		return nil, status.Error(codes.InvalidArgument, "new phone number is the same as the current one")
+	}
+	if findPhoneContactByNumber(user, phoneNumber.E164()) != nil {
+		return nil, status.Error(codes.AlreadyExists, "phone number already added")
+	}
+
+	proofMethod, err := oldNumberProofMethod(instanceID, *currentPhone, req.OldNumberProofMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt, err := s.startPhoneVerification(&VerificationAttempt{
+		UserID:                 userID,
+		InstanceID:             instanceID,
+		Purpose:                VERIFICATION_PURPOSE_CHANGE_PHONE,
+		PhoneNumber:            phoneNumber.E164(),
+		ReplacesContactID:      currentPhone.ID.Hex(),
+		ReplacesPhoneNumber:    currentPhone.Phone,
+		AwaitingOldNumberProof: proofMethod != "",
+		OldNumberProofMethod:   proofMethod,
+		ProofEmail:             user.Account.AccountID,
+		ProofEmailLanguage:     user.Account.PreferredLanguage,
+		Method:                 req.VerificationMethod,
+		Language:               user.Account.PreferredLanguage,
+		WhatsAppOptIn:          req.WhatsappOptIn,
+	}, req.ClientIp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.EditPhoneNumberResponse{
+		Success:             true,
+		VerificationToken:   attempt.Token,
+		VerificationMethod:  attempt.Method,
+		MaskedPhoneNumber:   attempt.maskedDestination(),
+		Step:                attempt.step(),
+		DeepLink:            attempt.deepLink(),
+		ExpiresAt:           attempt.ExpiresAt.Unix(),
+		ResendAvailableAt:   attempt.ResendAvailableAt.Unix(),
+		AttemptsRemaining:   int32(attempt.MaxAttempts - attempt.Attempts),
+		Warning:             attempt.PhoneWarning,
+		ReverseInstructions: attempt.reverseInstructions(),
+	}, nil
+}
+
+// startPhoneVerification completes attempt, whose user, purpose, phone number (in E.164 form)
+// and method are set by the caller, stores it as pending and sends the first code over the
+// requested method. The phone number is not attached to the user until VerifyPhoneNumber
+// succeeds.
+func (s *userManagementServer) startPhoneVerification(attempt *VerificationAttempt, clientIP string) (*VerificationAttempt, error) {
+	if !attempt.isLoginChallenge() {
+		warning, err := s.checkPhoneUniqueness(attempt.InstanceID, attempt.UserID, attempt.PhoneNumber)
+		if err != nil {
+			return nil, err
+		}
+		attempt.PhoneWarning = warning
+	}
+
+	if err := s.checkVerificationRateLimits(attempt.InstanceID, attempt.UserID, attempt.codeRecipient(), clientIP); err != nil {
+		return nil, err
+	}
 
 	verificationToken, err := s.generateVerificationToken()
 	if err != nil {
@@ -154,49 +295,93 @@ func (s *userManagementServer) EditPhoneNumber(ctx context.Context, req *api.Edi
 		return nil, status.Error(codes.Internal, "failed to generate verification token")
 	}
 
-	verificationCode := s.generateVerificationCode()
+	verificationCode, err := s.generateVerificationCode()
+	if err != nil {
+		log.Printf("Error generating verification code: %v", err)
+		return nil, status.Error(codes.Internal, "failed to generate verification code")
+	}
 
-	// Create verification attempt
-	attempt := &VerificationAttempt{
-		PhoneNumber: req.NewPhoneNumber,
-		Code:        verificationCode,
-		Token:       verificationToken,
-		Attempts:    0,
-		MaxAttempts: MAX_VERIFICATION_ATTEMPTS,
-		CreatedAt:   time.Now(),
-		ExpiresAt:   time.Now().Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute),
-		Status:      "pending",
-		RetryCount:  0,
-		MaxRetries:  MAX_RETRY_ATTEMPTS,
+	if attempt.Method == "" {
+		attempt.Method = "whatsapp" // default
+	}
+	if !containsString(verificationMethods, attempt.Method) {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported verification method: %s", attempt.Method)
+	}
+	// A Telegram chat proves the number of the account, not of the one the code must reach
+	if attempt.Method == VERIFICATION_METHOD_TELEGRAM && (attempt.isLoginChallenge() || attempt.AwaitingOldNumberProof) {
+		return nil, status.Error(codes.InvalidArgument, "telegram cannot be used for this verification")
+	}
+	// Login codes are entered on the website, which must not show them
+	if attempt.Method == VERIFICATION_METHOD_WHATSAPP_REVERSE {
+		if attempt.isLoginChallenge() {
+			return nil, status.Error(codes.InvalidArgument, "whatsapp-reverse cannot be used for this verification")
+		}
+		if whatsAppConfig.ForInstance(attempt.InstanceID).BusinessPhoneNumber == "" {
+			log.Printf("No WhatsApp business number configured for %s, reverse verification unavailable", attempt.InstanceID)
+			return nil, status.Error(codes.Unavailable, "reverse verification not available")
+		}
+	}
+	// Numbers stored earlier, e.g. without verification, may not take codes over this method
+	if recipient := attempt.codeRecipient(); recipient != "" {
+		number, err := phone.Parse(recipient, "")
+		if err != nil {
+			return nil, phoneValidationError(PHONE_REASON_INVALID, "phone not valid")
+		}
+		if err := checkNumberType(phoneVerificationConfig.ForInstance(attempt.InstanceID), number, attempt.Method); err != nil {
+			return nil, err
+		}
 	}
 
-	verificationAttempts[verificationToken] = attempt
+	now := time.Now()
+	attempt.setCode(verificationCode)
+	attempt.Token = verificationToken
+	attempt.ClientIP = clientIP
+	attempt.Attempts = 0
+	attempt.MaxAttempts = MAX_VERIFICATION_ATTEMPTS
+	attempt.CreatedAt = now
+	attempt.ExpiresAt = now.Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute)
+	attempt.ResendAvailableAt = now.Add(resendCooldown(0))
+	attempt.ResendCount = 0
+	attempt.Status = "pending"
+	attempt.RetryCount = 0
+	attempt.MaxRetries = MAX_RETRY_ATTEMPTS
 
-	// Send verification code based on method
-	verificationMethod := req.VerificationMethod
-	if verificationMethod == "" {
-		verificationMethod = "whatsapp" // default
+	if attempt.Purpose == VERIFICATION_PURPOSE_LOGIN {
+		// Nothing is sent to unknown numbers: answering before the send keeps the response time
+		// from telling whether a number is registered
+		verificationAttempts.put(attempt)
+		go s.sendLoginCode(*attempt)
+		return attempt, nil
 	}
 
-	err = s.sendVerificationCode(req.NewPhoneNumber, verificationCode, verificationMethod, 0)
+	err = s.sendAttemptCode(attempt)
 	if err != nil {
 		log.Printf("Error sending verification: %v", err)
-		delete(verificationAttempts, verificationToken)
 		return nil, status.Error(codes.Internal, "failed to send verification code")
 	}
+	verificationAttempts.put(attempt)
+	return attempt, nil
+}
 
-	return &api.EditPhoneNumberResponse{
-		Success:           true,
-		VerificationToken: verificationToken,
-	}, nil
+// sendLoginCode sends the code of a stored login attempt, removing the attempt if that fails.
+func (s *userManagementServer) sendLoginCode(attempt VerificationAttempt) {
+	if err := s.sendAttemptCode(&attempt); err != nil {
+		log.Printf("Error sending login code: %v", err)
+		verificationAttempts.remove(attempt.Token)
+		return
+	}
+	verificationAttempts.recordSend(&attempt)
 }
 
 func (s *userManagementServer) VerifyPhoneNumber(ctx context.Context, req *api.VerifyPhoneNumberRequest) (*api.VerifyPhoneNumberResponse, error) {
-	if req == nil || req.Token == "" || req.Code == "" {
+	if req == nil || req.Token == "" || req.Code == "" || req.AccessToken == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing arguments")
 	}
 
-	attempt, exists := verificationAttempts[req.Token]
+	attempt, exists, err := s.getOwnedVerificationAttempt(req.AccessToken, req.Token)
+	if err != nil {
+		return nil, err
+	}
 	if !exists {
 		return &api.VerifyPhoneNumberResponse{
 			Success:           false,
@@ -206,65 +391,84 @@ func (s *userManagementServer) VerifyPhoneNumber(ctx context.Context, req *api.V
 		}, nil
 	}
 
-	// Check if verification has expired
-	if time.Now().After(attempt.ExpiresAt) {
-		attempt.Status = "expired"
-		delete(verificationAttempts, req.Token)
+	// Count the guess, unless the attempt has expired or has no guesses left
+	attempt, attemptStatus := verificationAttempts.countGuess(req.Token, time.Now())
+	switch attemptStatus {
+	case "expired":
 		return &api.VerifyPhoneNumberResponse{
 			Success:           false,
 			Message:           "Verification code has expired",
 			Verified:          false,
 			AttemptsRemaining: 0,
 		}, nil
-	}
-
-	// Check if max attempts reached
-	if attempt.Attempts >= attempt.MaxAttempts {
-		attempt.Status = "failed"
-		delete(verificationAttempts, req.Token)
+	case "failed":
 		return &api.VerifyPhoneNumberResponse{
 			Success:           false,
 			Message:           "Maximum verification attempts exceeded",
 			Verified:          false,
 			AttemptsRemaining: 0,
 		}, nil
+	case "pending":
+	default:
+		return &api.VerifyPhoneNumberResponse{
+			Success:           false,
+			Message:           "Invalid or expired verification token",
+			Verified:          false,
+			AttemptsRemaining: 0,
+		}, nil
 	}
 
-	// Increment attempt counter
-	attempt.Attempts++
+	// The code of an email fallback never reached the phone, and the code of the reverse method
+	// is shown here: only the message sent from the phone to the business number proves
+	// possession of the number
+	if attempt.Method == VERIFICATION_METHOD_EMAIL_LINK || attempt.isReverseStep() {
+		return nil, status.Error(codes.FailedPrecondition, "verification must be completed by sending the WhatsApp message")
+	}
 
 	// Verify the code
-	if attempt.Code == req.Code {
-		attempt.Status = "verified"
-		
-		// Update user's phone number in database
-		userID, instanceID, err := s.ValidateToken(req.Token)
-		if err == nil {
-			// For add phone operation
-			if err := s.userDBservice.AddPhoneNumber(instanceID, userID, attempt.PhoneNumber, req.Token); err != nil {
-				log.Printf("Error updating phone number in database: %v", err)
-			}
+	if codeMatches(attempt.Code, req.Code) && attempt.AwaitingOldNumberProof {
+		attempt, err = s.completeOldNumberProof(attempt)
+		if status.Code(err) == codes.ResourceExhausted {
+			// The proof stays valid: the same code can be submitted again once the limit allows
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Error sending verification: %v", err)
+			verificationAttempts.remove(req.Token)
+			return nil, status.Error(codes.Internal, "failed to send verification code")
+		}
+		message := "Current phone number confirmed, a code was sent to the new number"
+		if attempt.isReverseStep() {
+			message = "Current phone number confirmed, send the code shown from the new number"
+		}
+		return &api.VerifyPhoneNumberResponse{
+			Success:             true,
+			Message:             message,
+			Verified:            false,
+			Step:                attempt.step(),
+			AttemptsRemaining:   int32(attempt.MaxAttempts - attempt.Attempts),
+			ReverseInstructions: attempt.reverseInstructions(),
+		}, nil
+	}
+
+	if codeMatches(attempt.Code, req.Code) {
+		message, err := s.completePhoneVerification(attempt)
+		if err != nil {
+			return nil, err
 		}
-		
-		// Clean up successful verification
-		go func() {
-			time.Sleep(5 * time.Second)
-			delete(verificationAttempts, req.Token)
-		}()
 
 		return &api.VerifyPhoneNumberResponse{
 			Success:           true,
-			Message:           "Phone number verified successfully",
+			Message:           message,
 			Verified:          true,
 			AttemptsRemaining: 0,
 		}, nil
 	}
 
 	attemptsRemaining := attempt.MaxAttempts - attempt.Attempts
-	
+
 	if attemptsRemaining == 0 {
-		attempt.Status = "failed"
-		delete(verificationAttempts, req.Token)
+		verificationAttempts.remove(req.Token)
 		return &api.VerifyPhoneNumberResponse{
 			Success:           false,
 			Message:           "Invalid verification code. Maximum attempts exceeded.",
@@ -281,51 +485,156 @@ func (s *userManagementServer) VerifyPhoneNumber(ctx context.Context, req *api.V
 	}, nil
 }
 
+// completePhoneVerification applies a successfully verified attempt to the user's phone contacts
+// and returns the message describing the change.
+func (s *userManagementServer) completePhoneVerification(attempt *VerificationAttempt) (string, error) {
+	// The number may have been verified by another account since the code was sent
+	if _, err := s.checkPhoneUniqueness(attempt.InstanceID, attempt.UserID, attempt.PhoneNumber); err != nil {
+		verificationAttempts.remove(attempt.Token)
+		return "", err
+	}
+
+	// Concurrent requests with the right code must not apply the change twice
+	if !verificationAttempts.claim(attempt.Token) {
+		return "", status.Error(codes.FailedPrecondition, "verification already completed or cancelled")
+	}
+
+	// Update user's phone number in database
+	var err error
+	message := "Phone number added successfully"
+	contactID := attempt.ContactID
+	var replaced *models.ContactInfo
+	switch attempt.Purpose {
+	case VERIFICATION_PURPOSE_CHANGE_PHONE:
+		contactID = attempt.ReplacesContactID
+		// Kept in the revoke token of the notification, to restore the contact as it was
+		user, userErr := s.userDBservice.GetUser(attempt.InstanceID, attempt.UserID)
+		if userErr != nil {
+			log.Printf("Error loading user: %v", userErr)
+			return "", status.Error(codes.Internal, "failed to update phone number")
+		}
+		replaced = findPhoneContact(user, contactID)
+		err = s.userDBservice.ReplacePhoneNumber(attempt.InstanceID, attempt.UserID, contactID, attempt.ReplacesPhoneNumber, attempt.PhoneNumber, attempt.Method)
+		message = "Phone number changed successfully"
+	case VERIFICATION_PURPOSE_VERIFY_PHONE:
+		err = s.userDBservice.ConfirmPhoneNumber(attempt.InstanceID, attempt.UserID, contactID, attempt.Method)
+		message = "Phone number verified successfully"
+	default:
+		// A single update, so a failure cannot leave an unverified contact behind
+		contactID, err = s.userDBservice.AddVerifiedPhoneNumber(attempt.InstanceID, attempt.UserID, attempt.PhoneNumber, attempt.Method)
+	}
+	if err != nil {
+		log.Printf("Error updating phone number in database: %v", err)
+		return "", status.Error(codes.Internal, "failed to update phone number")
+	}
+	if err := s.ensurePrimaryPhoneNumber(attempt.InstanceID, attempt.UserID); err != nil {
+		log.Printf("Error setting primary phone number: %v", err)
+	}
+	if attempt.WhatsAppOptIn {
+		if err := s.grantWhatsAppConsent(attempt.InstanceID, attempt.UserID, attempt.PhoneNumber, CONSENT_SOURCE_PHONE_VERIFICATION, attempt.ClientIP); err != nil {
+			log.Printf("Error recording WhatsApp consent: %v", err)
+		}
+	}
+	if attempt.Purpose == VERIFICATION_PURPOSE_CHANGE_PHONE {
+		if err := s.revokeWhatsAppConsent(attempt.InstanceID, attempt.UserID, attempt.ReplacesPhoneNumber, CONSENT_SOURCE_PHONE_REMOVED); err != nil {
+			log.Printf("Error revoking WhatsApp consent: %v", err)
+		}
+	}
+
+	switch attempt.Purpose {
+	case VERIFICATION_PURPOSE_CHANGE_PHONE:
+		go s.notifyPhoneNumberChange(attempt.InstanceID, attempt.UserID, PHONE_CHANGE_CHANGED, attempt.ReplacesContactID, attempt.ReplacesPhoneNumber, attempt.PhoneNumber, replaced)
+	case VERIFICATION_PURPOSE_ADD_PHONE:
+		go s.notifyPhoneNumberChange(attempt.InstanceID, attempt.UserID, PHONE_CHANGE_ADDED, contactID, "", attempt.PhoneNumber, nil)
+	}
+
+	// Clean up successful verification
+	verificationAttempts.removeAfter(attempt.Token, 5*time.Second)
+
+	return message, nil
+}
+
 func (s *userManagementServer) ResendVerificationCode(ctx context.Context, req *api.ResendVerificationCodeRequest) (*api.ResendVerificationCodeResponse, error) {
-	if req == nil || req.Token == "" {
+	if req == nil || req.Token == "" || req.AccessToken == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing arguments")
 	}
 
-	attempt, exists := verificationAttempts[req.Token]
+	attempt, exists, err := s.getOwnedVerificationAttempt(req.AccessToken, req.Token)
+	if err != nil {
+		return nil, err
+	}
 	if !exists {
 		return nil, status.Error(codes.NotFound, "Invalid or expired verification token")
 	}
 
 	if time.Now().After(attempt.ExpiresAt) {
-		attempt.Status = "expired"
-		delete(verificationAttempts, req.Token)
+		verificationAttempts.remove(req.Token)
 		return nil, status.Error(codes.DeadlineExceeded, "Verification session has expired")
 	}
 
-	// Generate new code and reset attempts
-	newCode := s.generateVerificationCode()
-	attempt.Code = newCode
-	attempt.Attempts = 0
-	attempt.RetryCount = 0
-	attempt.ExpiresAt = time.Now().Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute)
+	if attempt.ResendCount >= MAX_RESENDS_PER_VERIFICATION {
+		return nil, status.Error(codes.FailedPrecondition, "Maximum number of resends reached")
+	}
+
+	if now := time.Now(); now.Before(attempt.ResendAvailableAt) {
+		return nil, resourceExhaustedError("Resend not available yet", attempt.ResendAvailableAt.Sub(now))
+	}
+
+	if err := s.checkVerificationRateLimits(attempt.InstanceID, attempt.UserID, attempt.codeRecipient(), req.ClientIp); err != nil {
+		return nil, err
+	}
+
+	// Generate new code, keeping the guesses already used
+	newCode, err := s.generateVerificationCode()
+	if err != nil {
+		log.Printf("Error generating verification code: %v", err)
+		return nil, status.Error(codes.Internal, "Failed to resend verification code")
+	}
+	attempt, exists = verificationAttempts.update(req.Token, func(attempt *VerificationAttempt) {
+		attempt.setCode(newCode)
+		attempt.MaxAttempts += VERIFICATION_ATTEMPTS_PER_RESEND
+		attempt.RetryCount = 0
+		attempt.ResendCount++
+		attempt.ExpiresAt = time.Now().Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute)
+		attempt.ResendAvailableAt = time.Now().Add(resendCooldown(attempt.ResendCount))
+	})
+	if !exists {
+		return nil, status.Error(codes.NotFound, "Invalid or expired verification token")
+	}
 
-	err := s.sendVerificationCode(attempt.PhoneNumber, newCode, "whatsapp", 0)
+	err = s.sendAttemptCode(attempt)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Failed to resend verification code")
 	}
+	verificationAttempts.recordSend(attempt)
 
 	return &api.ResendVerificationCodeResponse{
-		Success:           true,
-		VerificationToken: req.Token,
-		Message:           "New verification code sent",
-		ExpiresAt:         attempt.ExpiresAt.Unix(),
-		AttemptsRemaining: int32(MAX_VERIFICATION_ATTEMPTS),
+		Success:             true,
+		VerificationToken:   req.Token,
+		Message:             "New verification code sent",
+		VerificationMethod:  attempt.Method,
+		MaskedPhoneNumber:   attempt.maskedDestination(),
+		Step:                attempt.step(),
+		DeepLink:            attempt.deepLink(),
+		ExpiresAt:           attempt.ExpiresAt.Unix(),
+		ResendAvailableAt:   attempt.ResendAvailableAt.Unix(),
+		ResendsRemaining:    int32(MAX_RESENDS_PER_VERIFICATION - attempt.ResendCount),
+		AttemptsRemaining:   int32(attempt.MaxAttempts - attempt.Attempts),
+		ReverseInstructions: attempt.reverseInstructions(),
 	}, nil
 }
 
 func (s *userManagementServer) CancelVerification(ctx context.Context, req *api.CancelVerificationRequest) (*api.CancelVerificationResponse, error) {
-	if req == nil || req.Token == "" {
+	if req == nil || req.Token == "" || req.AccessToken == "" {
 		return nil, status.Error(codes.InvalidArgument, "missing arguments")
 	}
 
-	_, exists := verificationAttempts[req.Token]
+	_, exists, err := s.getOwnedVerificationAttempt(req.AccessToken, req.Token)
+	if err != nil {
+		return nil, err
+	}
 	if exists {
-		delete(verificationAttempts, req.Token)
+		verificationAttempts.remove(req.Token)
 	}
 
 	return &api.CancelVerificationResponse{
@@ -334,10 +643,114 @@ func (s *userManagementServer) CancelVerification(ctx context.Context, req *api.
 	}, nil
 }
 
-func (s *userManagementServer) isValidPhoneNumber(phoneNumber string) bool {
-	// E.164 format validation: +[country code][number]
-	phoneRegex := regexp.MustCompile(`^\+[1-9]\d{1,14}$`)
-	return phoneRegex.MatchString(phoneNumber)
+func (s *userManagementServer) RemovePhoneNumber(ctx context.Context, req *api.RemovePhoneNumberRequest) (*api.RemovePhoneNumberResponse, error) {
+	if req == nil || req.Token == "" || req.ContactId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// Removing a contact requires the password, or an access token issued just before
+	if err := checkPhoneChangeReauth(instanceID, user, req.Token, req.Password, req.ClientIp); err != nil {
+		return nil, err
+	}
+
+	phoneContact := findPhoneContact(user, req.ContactId)
+	if phoneContact == nil {
+		return nil, status.Error(codes.NotFound, "phone number not found")
+	}
+	secondFactor, err := s.userDBservice.GetPhoneSecondFactor(instanceID, userID)
+	if err == nil && secondFactor.Enabled && secondFactor.ContactID == phoneContact.ID {
+		return nil, status.Error(codes.FailedPrecondition, "phone number is used as second factor")
+	}
+
+	if err := s.userDBservice.RemovePhoneNumber(instanceID, userID, req.ContactId); err != nil {
+		log.Printf("Error removing phone number: %v", err)
+		return nil, status.Error(codes.Internal, "failed to remove phone number")
+	}
+
+	// Pending verifications involving the removed number must not complete afterwards
+	verificationAttempts.removeWhere(func(attempt *VerificationAttempt) bool {
+		return attempt.UserID == userID && attempt.InstanceID == instanceID &&
+			(attempt.PhoneNumber == phoneContact.Phone || attempt.ReplacesContactID == req.ContactId)
+	})
+
+	if phoneContact.Primary {
+		if err := s.ensurePrimaryPhoneNumber(instanceID, userID); err != nil {
+			log.Printf("Error setting primary phone number: %v", err)
+		}
+	}
+
+	if err := s.revokeWhatsAppConsent(instanceID, userID, phoneContact.Phone, CONSENT_SOURCE_PHONE_REMOVED); err != nil {
+		log.Printf("Error revoking WhatsApp consent: %v", err)
+	}
+	if err := s.resetUnreachableNotificationChannels(instanceID, userID); err != nil {
+		log.Printf("Error updating notification preferences: %v", err)
+	}
+
+	s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_PHONE_REMOVED, maskPhoneNumber(phoneContact.Phone))
+	go s.notifyPhoneNumberChange(instanceID, userID, PHONE_CHANGE_REMOVED, req.ContactId, phoneContact.Phone, "", phoneContact)
+
+	return &api.RemovePhoneNumberResponse{
+		Success: true,
+		Message: "Phone number removed successfully",
+	}, nil
+}
+
+// resendCooldown returns the delay to wait after the given number of resends.
+func resendCooldown(resendCount int) time.Duration {
+	if resendCount >= len(resendCooldowns) {
+		return resendCooldowns[len(resendCooldowns)-1]
+	}
+	return resendCooldowns[resendCount]
+}
+
+// getOwnedVerificationAttempt looks up the attempt for verificationToken and checks that it was
+// started by the user the access token belongs to. Attempts of other users are reported as
+// not existing, so a leaked verification token cannot be used to probe or complete their flow.
+func (s *userManagementServer) getOwnedVerificationAttempt(accessToken, verificationToken string) (*VerificationAttempt, bool, error) {
+	userID, instanceID, err := s.ValidateToken(accessToken)
+	if err != nil {
+		return nil, false, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	attempt, exists := verificationAttempts.get(verificationToken)
+	if !exists || attempt.UserID != userID || attempt.InstanceID != instanceID || attempt.isLoginChallenge() {
+		return nil, false, nil
+	}
+	return attempt, true, nil
+}
+
+// checkPhoneChangeReauth checks that the user re-authenticated before a sensitive phone change:
+// with password, if given, or with an access token issued at most REAUTH_MAX_AGE ago.
+func checkPhoneChangeReauth(instanceID string, user models.User, accessToken, password, clientIP string) error {
+	if password != "" {
+		return checkReauthPassword(instanceID, user, password, clientIP)
+	}
+	if issuedAt, err := tokenIssuedAt(accessToken); err != nil || time.Since(issuedAt) > REAUTH_MAX_AGE {
+		return status.Error(codes.PermissionDenied, "re-authentication required")
+	}
+	return nil
+}
+
+// tokenIssuedAt returns when the access token was issued.
+func tokenIssuedAt(accessToken string) (time.Time, error) {
+	claims, valid, err := tokens.ValidateToken(accessToken)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !valid {
+		return time.Time{}, errors.New("invalid token")
+	}
+	return time.Unix(claims.IssuedAt, 0), nil
 }
 
 func (s *userManagementServer) generateVerificationToken() (string, error) {
@@ -348,51 +761,66 @@ func (s *userManagementServer) generateVerificationToken() (string, error) {
 	return fmt.Sprintf("whatsapp_%s_%d", hex.EncodeToString(bytes), time.Now().Unix()), nil
 }
 
-func (s *userManagementServer) generateVerificationCode() string {
+func (s *userManagementServer) generateVerificationCode() (string, error) {
 	// Generate a 6-digit code
-	code := make([]byte, 6)
-	for i := range code {
-		code[i] = byte('0' + (rand.Int() % 10))
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// codeMatches reports whether code is the expected verification code, comparing in constant
+// time so response times do not reveal how many leading digits are right.
+func codeMatches(expected, code string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1
+}
+
+// maskPhoneNumber hides all but the country prefix and the last digits of a phone number,
+// so it can be echoed back to clients without disclosing the full destination.
+func maskPhoneNumber(phoneNumber string) string {
+	if len(phoneNumber) <= 6 {
+		return phoneNumber
 	}
-	return string(code)
+	return phoneNumber[:3] + strings.Repeat("*", len(phoneNumber)-6) + phoneNumber[len(phoneNumber)-3:]
 }
 
-func (s *userManagementServer) sendVerificationCode(phoneNumber, code, method string, retryCount int) error {
+func (s *userManagementServer) sendVerificationCode(instanceID, phoneNumber, code, method, language string, retryCount int) error {
 	switch method {
 	case "whatsapp":
-		return s.sendWhatsAppVerification(phoneNumber, code, retryCount)
+		return s.sendWhatsAppVerification(instanceID, phoneNumber, code, language, retryCount)
 	case "sms":
-		return s.sendSMSVerification(phoneNumber, code, retryCount)
+		return errSMSUnavailable
+	case "voice":
+		return s.sendVoiceVerification(phoneNumber, code, language)
 	default:
 		return fmt.Errorf("unsupported verification method: %s", method)
 	}
 }
 
-func (s *userManagementServer) sendWhatsAppVerification(phoneNumber, code string, retryCount int) error {
-	// Initialize WhatsApp client
-	whatsappClient := NewWhatsAppClient()
-	
+func (s *userManagementServer) sendWhatsAppVerification(instanceID, phoneNumber, code, language string, retryCount int) error {
+	// A template paused or rejected in every language fails right away, so the caller can fall
+	// back to the email link
+	config := whatsAppConfig.ForInstance(instanceID)
+	templateLanguage, ok := config.templateLanguage(config.VerificationTemplate, language)
+	if !ok {
+		return fmt.Errorf("%w: %s", errWhatsAppTemplateUnavailable, config.VerificationTemplate.Name)
+	}
+
+	// WhatsApp client sending from the business number of the instance
+	whatsappClient := whatsAppClientFor(instanceID)
+
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	
+
 	// Send with retry mechanism
-	err := whatsappClient.SendWithRetry(ctx, phoneNumber, code, MAX_RETRY_ATTEMPTS)
+	err := whatsappClient.SendWithRetry(ctx, phoneNumber, code, templateLanguage, MAX_RETRY_ATTEMPTS)
 	if err != nil {
-		log.Printf("Failed to send WhatsApp verification to %s: %v", phoneNumber, err)
+		log.Printf("Failed to send WhatsApp verification to %s: %v", maskPhoneNumber(phoneNumber), err)
 		return fmt.Errorf("failed to send WhatsApp verification: %w", err)
 	}
-	
-	log.Printf("WhatsApp verification sent successfully to %s", phoneNumber)
-	return nil
-}
 
-func (s *userManagementServer) sendSMSVerification(phoneNumber, code string, retryCount int) error {
-	// Simulate SMS API call with retry logic
-	message := fmt.Sprintf("Your InfluenzaNet verification code is: %s. This code will expire in %d minutes.", code, VERIFICATION_CODE_EXPIRY_MINUTES)
-	
-	log.Printf("Sending SMS verification to %s (attempt %d): %s", phoneNumber, retryCount+1, message)
-	
-	// In real implementation, make actual SMS API call here
+	log.Printf("WhatsApp verification sent successfully to %s", maskPhoneNumber(phoneNumber))
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestResendCooldown(t *testing.T) {
+	tests := []struct {
+		resendCount int
+		cooldown    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 60 * time.Second},
+		{2, 5 * time.Minute},
+		{MAX_RESENDS_PER_VERIFICATION, 5 * time.Minute},
+		{10, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d resends", tt.resendCount), func(t *testing.T) {
+			if cooldown := resendCooldown(tt.resendCount); cooldown != tt.cooldown {
+				t.Errorf("expected %v, got %v", tt.cooldown, cooldown)
+			}
+		})
+	}
+}
+
+func TestCodeMatches(t *testing.T) {
+	tests := []struct {
+		expected string
+		code     string
+		matches  bool
+	}{
+		{"123456", "123456", true},
+		{"123456", "123457", false},
+		{"123456", "12345", false},
+		{"123456", "1234567", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected+"/"+tt.code, func(t *testing.T) {
+			if matches := codeMatches(tt.expected, tt.code); matches != tt.matches {
+				t.Errorf("expected %v, got %v", tt.matches, matches)
+			}
+		})
+	}
+}
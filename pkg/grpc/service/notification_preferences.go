@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Message types users choose a channel for
+const (
+	NOTIFICATION_TYPE_NEWSLETTER       = "newsletter"
+	NOTIFICATION_TYPE_WEEKLY_REMINDER  = REMINDER_TYPE_WEEKLY
+	NOTIFICATION_TYPE_ACCOUNT_SECURITY = "account-security"
+)
+
+const NOTIFICATION_CHANNEL_EMAIL = "email"
+
+// Channels each message type can be received on. Reminders link to surveys, which SMS cannot
+// carry as a template button. No SMS provider sends notifications yet, so SMS is not offered.
+var notificationChannels = map[string][]string{
+	NOTIFICATION_TYPE_NEWSLETTER:       {NOTIFICATION_CHANNEL_EMAIL, PHONE_CHANNEL_WHATSAPP},
+	NOTIFICATION_TYPE_WEEKLY_REMINDER:  {NOTIFICATION_CHANNEL_EMAIL, PHONE_CHANNEL_WHATSAPP},
+	NOTIFICATION_TYPE_ACCOUNT_SECURITY: {NOTIFICATION_CHANNEL_EMAIL, PHONE_CHANNEL_WHATSAPP},
+}
+
+// notificationTypeOf returns the preference type a message type is sent under: study
+// invitations follow the weekly reminder choice.
+func notificationTypeOf(messageType string) string {
+	if messageType == REMINDER_TYPE_STUDY_INVITATION {
+		return NOTIFICATION_TYPE_WEEKLY_REMINDER
+	}
+	return messageType
+}
+
+func (s *userManagementServer) GetNotificationPreferences(ctx context.Context, req *api.GetNotificationPreferencesRequest) (*api.NotificationPreferences, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	preferences, err := s.userDBservice.GetNotificationPreferences(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return notificationPreferencesToAPI(preferences), nil
+}
+
+// UpdateNotificationPreferences sets the channels of the given message types, leaving the others
+// unchanged. An empty channel resets a type to its default. Phone channels require a verified
+// number accepting them, and WhatsApp the user's opt-in.
+func (s *userManagementServer) UpdateNotificationPreferences(ctx context.Context, req *api.UpdateNotificationPreferencesRequest) (*api.NotificationPreferences, error) {
+	if req == nil || req.Token == "" || len(req.Channels) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	preferences, err := s.userDBservice.GetNotificationPreferences(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if preferences.Channels == nil {
+		preferences.Channels = map[string]string{}
+	}
+
+	for messageType, channel := range req.Channels {
+		allowed, ok := notificationChannels[messageType]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown message type: %s", messageType)
+		}
+		if channel == "" {
+			delete(preferences.Channels, messageType)
+			continue
+		}
+		if !containsString(allowed, channel) {
+			return nil, status.Errorf(codes.InvalidArgument, "channel %s not available for %s", channel, messageType)
+		}
+		if channel != NOTIFICATION_CHANNEL_EMAIL && s.notificationPhoneContact(instanceID, user, channel) == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "no verified phone number accepting %s", channel)
+		}
+		preferences.Channels[messageType] = channel
+	}
+
+	if err := s.userDBservice.SetNotificationPreferences(instanceID, userID, preferences); err != nil {
+		log.Printf("Error updating notification preferences: %v", err)
+		return nil, status.Error(codes.Internal, "failed to update notification preferences")
+	}
+	return notificationPreferencesToAPI(preferences), nil
+}
+
+func notificationPreferencesToAPI(preferences models.NotificationPreferences) *api.NotificationPreferences {
+	channels := map[string]string{}
+	for messageType := range notificationChannels {
+		channels[messageType] = offeredChannel(preferences, messageType)
+	}
+	return &api.NotificationPreferences{
+		Channels:  channels,
+		UpdatedAt: preferences.UpdatedAt,
+	}
+}
+
+// notificationChannel returns the channel the user chose for messageType, or "" if they did not
+// choose or their preferences cannot be read.
+func (s *userManagementServer) notificationChannel(instanceID, userID, messageType string) string {
+	preferences, err := s.userDBservice.GetNotificationPreferences(instanceID, userID)
+	if err != nil {
+		log.Printf("Error reading notification preferences: %v", err)
+		return ""
+	}
+	return offeredChannel(preferences, notificationTypeOf(messageType))
+}
+
+// offeredChannel returns the channel chosen for messageType, or "" if none was chosen or the
+// chosen one is not offered anymore, e.g. SMS chosen before it was withdrawn.
+func offeredChannel(preferences models.NotificationPreferences, messageType string) string {
+	channel := preferences.Channel(messageType)
+	if !containsString(notificationChannels[messageType], channel) {
+		return ""
+	}
+	return channel
+}
+
+// notificationPhoneContact returns the phone contact messages over a phone channel go to: the
+// primary one if it accepts the channel, or else the first confirmed one that does. WhatsApp
+// also requires the user's consent for the number.
+func (s *userManagementServer) notificationPhoneContact(instanceID string, user models.User, channel string) *models.ContactInfo {
+	candidates := []*models.ContactInfo{}
+	if primary := primaryPhoneContact(user); primary != nil {
+		candidates = append(candidates, primary)
+	}
+	for i := range user.ContactInfos {
+		if user.ContactInfos[i].Type == "phone" && !user.ContactInfos[i].Primary {
+			candidates = append(candidates, &user.ContactInfos[i])
+		}
+	}
+
+	for _, contact := range candidates {
+		if !phoneChannelAllowed(*contact, channel) {
+			continue
+		}
+		if channel == PHONE_CHANNEL_WHATSAPP && !s.hasWhatsAppConsent(instanceID, user.ID.Hex(), contact.Phone) {
+			continue
+		}
+		return contact
+	}
+	return nil
+}
+
+// resetUnreachableNotificationChannels resets message types the user chose a phone channel for
+// to their default when no phone number of the user accepts that channel anymore, e.g. after
+// the number was removed.
+func (s *userManagementServer) resetUnreachableNotificationChannels(instanceID, userID string) error {
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return err
+	}
+	preferences, err := s.userDBservice.GetNotificationPreferences(instanceID, userID)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for messageType, channel := range preferences.Channels {
+		if channel != NOTIFICATION_CHANNEL_EMAIL && s.notificationPhoneContact(instanceID, user, channel) == nil {
+			delete(preferences.Channels, messageType)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.userDBservice.SetNotificationPreferences(instanceID, userID, preferences)
+}
+
+// notifySecurityEventByPhone sends a security notice to the phone of users who chose to receive
+// account security messages over WhatsApp. The email notice is sent regardless, as it carries
+// the links to react to the event.
+func (s *userManagementServer) notifySecurityEventByPhone(instanceID string, user models.User, text string) {
+	if s.notificationChannel(instanceID, user.ID.Hex(), NOTIFICATION_TYPE_ACCOUNT_SECURITY) != PHONE_CHANNEL_WHATSAPP {
+		return
+	}
+	contact := s.notificationPhoneContact(instanceID, user, PHONE_CHANNEL_WHATSAPP)
+	if contact == nil {
+		log.Printf("Security notice: user %s has no phone number accepting WhatsApp", user.ID.Hex())
+		return
+	}
+
+	err := s.sendWhatsAppNotification(instanceID, NOTIFICATION_TYPE_ACCOUNT_SECURITY, contact.Phone, user.Account.PreferredLanguage, text)
+	if err != nil {
+		log.Printf("Security notice: cannot send WhatsApp message to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// sendWhatsAppNotification sends the notification template of messageType with text as its body
+// parameter.
+func (s *userManagementServer) sendWhatsAppNotification(instanceID, messageType, phoneNumber, language, text string) error {
+	template, templateLanguage, ok := whatsAppConfig.ForInstance(instanceID).notificationTemplate(messageType, language)
+	if !ok {
+		return fmt.Errorf("%w: %s", errWhatsAppTemplateUnavailable, messageType)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return whatsAppClientFor(instanceID).SendTemplate(ctx, phoneNumber, template.Name, templateLanguage, []WhatsAppTemplateComponent{
+		{
+			Type:       "body",
+			Parameters: []WhatsAppTemplateParameter{{Type: "text", Text: text}},
+		},
+	})
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Values of the changePhoneProof setting: what a user has to prove before a confirmed phone
+// number can be replaced.
+const (
+	CHANGE_PHONE_PROOF_NONE           = "none"
+	CHANGE_PHONE_PROOF_PHONE          = "phone"
+	CHANGE_PHONE_PROOF_PHONE_OR_EMAIL = "phone_or_email"
+)
+
+// Where the proof code for the current number is sent
+const (
+	PROOF_METHOD_PHONE = "phone"
+	PROOF_METHOD_EMAIL = "email"
+)
+
+// Steps of a verification attempt, reported to the client
+const (
+	VERIFICATION_STEP_VERIFY_OLD_PHONE = "verify-old-phone"
+	VERIFICATION_STEP_VERIFY_NEW_PHONE = "verify-new-phone"
+)
+
+const EMAIL_TYPE_PHONE_CHANGE_VERIFICATION = "phone-change-verification"
+
+// oldNumberProofMethod returns how the user must prove possession of the current number before
+// changing it to a new one, or "" if no proof is needed.
+func oldNumberProofMethod(instanceID string, currentPhone models.ContactInfo, requestedMethod string) (string, error) {
+	policy := phoneVerificationConfig.ForInstance(instanceID).ChangePhoneProof
+	if policy == "" || policy == CHANGE_PHONE_PROOF_NONE || currentPhone.ConfirmedAt <= 0 {
+		return "", nil
+	}
+
+	switch requestedMethod {
+	case "", PROOF_METHOD_PHONE:
+		return PROOF_METHOD_PHONE, nil
+	case PROOF_METHOD_EMAIL:
+		if policy != CHANGE_PHONE_PROOF_PHONE_OR_EMAIL {
+			return "", status.Error(codes.InvalidArgument, "email proof not allowed")
+		}
+		return PROOF_METHOD_EMAIL, nil
+	default:
+		return "", status.Error(codes.InvalidArgument, "unknown proof method")
+	}
+}
+
+// step returns the step the attempt is waiting for.
+func (attempt *VerificationAttempt) step() string {
+	if attempt.AwaitingOldNumberProof {
+		return VERIFICATION_STEP_VERIFY_OLD_PHONE
+	}
+	return VERIFICATION_STEP_VERIFY_NEW_PHONE
+}
+
+// maskedDestination returns where the current code of the attempt was sent, masked.
+func (attempt *VerificationAttempt) maskedDestination() string {
+	if attempt.Method == VERIFICATION_METHOD_EMAIL_LINK {
+		return maskEmail(attempt.FallbackEmail)
+	}
+	if !attempt.AwaitingOldNumberProof {
+		return maskPhoneNumber(attempt.PhoneNumber)
+	}
+	if attempt.OldNumberProofMethod == PROOF_METHOD_EMAIL {
+		return maskEmail(attempt.ProofEmail)
+	}
+	return maskPhoneNumber(attempt.ReplacesPhoneNumber)
+}
+
+// codeRecipient returns the phone number the current code of the attempt is sent to, or has to
+// be sent from for the reverse method, or "" if it goes to an email address.
+func (attempt *VerificationAttempt) codeRecipient() string {
+	if attempt.Method == VERIFICATION_METHOD_EMAIL_LINK {
+		return ""
+	}
+	if !attempt.AwaitingOldNumberProof {
+		return attempt.PhoneNumber
+	}
+	if attempt.OldNumberProofMethod == PROOF_METHOD_EMAIL {
+		return ""
+	}
+	return attempt.ReplacesPhoneNumber
+}
+
+// sendAttemptCode sends the current code of the attempt: to the current number (or account
+// email) while its possession has not been proven yet, to the new number otherwise.
+func (s *userManagementServer) sendAttemptCode(attempt *VerificationAttempt) error {
+	if attempt.Purpose == VERIFICATION_PURPOSE_LOGIN && attempt.UserID == "" {
+		// Login requested for an unknown number: answer as usual, but send nothing
+		return nil
+	}
+	if attempt.isReverseStep() {
+		// The code is shown on the website only
+		return nil
+	}
+	if attempt.Method == VERIFICATION_METHOD_TELEGRAM {
+		return s.sendTelegramVerification(attempt)
+	}
+	if attempt.Method == VERIFICATION_METHOD_EMAIL_LINK {
+		return s.sendEmailFallbackLink(attempt)
+	}
+	if !attempt.AwaitingOldNumberProof {
+		return s.sendWithFallback(attempt)
+	}
+	if attempt.OldNumberProofMethod == PROOF_METHOD_EMAIL {
+		return s.sendVerificationCodeByEmail(attempt.InstanceID, attempt.ProofEmail, attempt.ProofEmailLanguage, attempt.Code)
+	}
+	if attempt.Method == PHONE_CHANNEL_WHATSAPP && !s.whatsAppAllowedForAttempt(attempt, attempt.ReplacesPhoneNumber) {
+		return errNoWhatsAppConsent
+	}
+	return s.sendVerificationCode(attempt.InstanceID, attempt.ReplacesPhoneNumber, attempt.Code, attempt.Method, attempt.Language, 0)
+}
+
+// completeOldNumberProof moves the attempt to the verification of the new number, sending a
+// fresh code to it, and returns the updated attempt. The send to the new number counts against
+// the rate limits like the first one did for the current number.
+func (s *userManagementServer) completeOldNumberProof(attempt *VerificationAttempt) (*VerificationAttempt, error) {
+	if err := s.checkVerificationRateLimits(attempt.InstanceID, attempt.UserID, attempt.PhoneNumber, attempt.ClientIP); err != nil {
+		return nil, err
+	}
+
+	code, err := s.generateVerificationCode()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	attempt, exists := verificationAttempts.update(attempt.Token, func(attempt *VerificationAttempt) {
+		attempt.AwaitingOldNumberProof = false
+		attempt.setCode(code)
+		attempt.Attempts = 0
+		attempt.MaxAttempts = MAX_VERIFICATION_ATTEMPTS
+		attempt.ResendCount = 0
+		attempt.ExpiresAt = now.Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute)
+		attempt.ResendAvailableAt = now.Add(resendCooldown(0))
+	})
+	if !exists {
+		return nil, fmt.Errorf("verification attempt cancelled")
+	}
+	if err := s.sendAttemptCode(attempt); err != nil {
+		return nil, err
+	}
+	verificationAttempts.recordSend(attempt)
+	return attempt, nil
+}
+
+func (s *userManagementServer) sendVerificationCodeByEmail(instanceID, email, language, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:  instanceID,
+		To:          []string{email},
+		MessageType: EMAIL_TYPE_PHONE_CHANGE_VERIFICATION,
+		ContentInfos: map[string]string{
+			"verificationCode": code,
+			"expiresInMinutes": fmt.Sprintf("%d", VERIFICATION_CODE_EXPIRY_MINUTES),
+		},
+		PreferredLanguage: language,
+	})
+	if err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+	return nil
+}
+
+// maskEmail keeps the first character of the local part and the domain of an email address.
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return email
+	}
+	return email[:1] + strings.Repeat("*", at-1) + email[at:]
+}
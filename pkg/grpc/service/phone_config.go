@@ -0,0 +1,123 @@
+package service
+
+import (
+	"log"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+const ENV_PHONE_VERIFICATION_CONFIG_FILE = "PHONE_VERIFICATION_CONFIG_FILE"
+
+type InstancePhoneConfig struct {
+	DefaultRegion string `yaml:"defaultRegion"`
+	// Country calling codes numbers may belong to, e.g. "39". Empty allows all.
+	AllowedCountryCodes []string `yaml:"allowedCountryCodes"`
+	// E.164 prefixes that are always rejected, e.g. "+39899".
+	BlockedPrefixes []string `yaml:"blockedPrefixes"`
+	// Number types (see phone.NumberType) that are accepted. Empty allows all.
+	AllowedNumberTypes []string `yaml:"allowedNumberTypes"`
+	// Number types accepted for codes sent over a verification method, replacing
+	// AllowedNumberTypes for it, e.g. fixed-line numbers for "voice".
+	AllowedNumberTypesByMethod map[string][]string `yaml:"allowedNumberTypesByMethod"`
+	// What to do when the number is already used by another account: "allow" (default), "warn"
+	// or "reject".
+	PhoneUniqueness string `yaml:"phoneUniqueness"`
+	// Proof of possession of a confirmed number required before changing it: "none" (default),
+	// "phone" or "phone_or_email".
+	ChangePhoneProof string `yaml:"changePhoneProof"`
+	// Age in days after which a confirmed number is due for re-verification. 0 disables it.
+	ReverifyAfterDays int `yaml:"reverifyAfterDays"`
+	// Allow logging in with a code sent to a verified phone number. Disabled by default.
+	PhoneLogin *bool `yaml:"phoneLogin"`
+	// When neither WhatsApp nor SMS can deliver the code, email a link to the reverse flow to
+	// the confirmed account address. Disabled by default.
+	EmailFallback *bool `yaml:"emailFallback"`
+	// Version of the WhatsApp messaging policy users opt in to, stored with their consent.
+	WhatsAppConsentPolicyVersion string `yaml:"whatsAppConsentPolicyVersion"`
+}
+
+// PhoneVerificationConfig holds the phone verification settings, with per-instance overrides
+// of the top level defaults.
+type PhoneVerificationConfig struct {
+	InstancePhoneConfig `yaml:",inline"`
+	Instances           map[string]InstancePhoneConfig `yaml:"instances"`
+}
+
+var phoneVerificationConfig = loadPhoneVerificationConfig(os.Getenv(ENV_PHONE_VERIFICATION_CONFIG_FILE))
+
+func loadPhoneVerificationConfig(path string) PhoneVerificationConfig {
+	config := PhoneVerificationConfig{}
+	if path == "" {
+		return config
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read phone verification config %s: %v", path, err)
+		return config
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		log.Printf("Failed to parse phone verification config %s: %v", path, err)
+		return PhoneVerificationConfig{}
+	}
+	return config
+}
+
+// ForInstance returns the settings of instanceID, falling back to the defaults for unset values.
+func (c PhoneVerificationConfig) ForInstance(instanceID string) InstancePhoneConfig {
+	config := c.Instances[instanceID]
+	if config.DefaultRegion == "" {
+		config.DefaultRegion = c.DefaultRegion
+	}
+	if config.AllowedCountryCodes == nil {
+		config.AllowedCountryCodes = c.AllowedCountryCodes
+	}
+	if config.BlockedPrefixes == nil {
+		config.BlockedPrefixes = c.BlockedPrefixes
+	}
+	if config.AllowedNumberTypes == nil {
+		config.AllowedNumberTypes = c.AllowedNumberTypes
+	}
+	if config.AllowedNumberTypesByMethod == nil {
+		config.AllowedNumberTypesByMethod = c.AllowedNumberTypesByMethod
+	}
+	if config.PhoneUniqueness == "" {
+		config.PhoneUniqueness = c.PhoneUniqueness
+	}
+	if config.ChangePhoneProof == "" {
+		config.ChangePhoneProof = c.ChangePhoneProof
+	}
+	if config.ReverifyAfterDays == 0 {
+		config.ReverifyAfterDays = c.ReverifyAfterDays
+	}
+	if config.PhoneLogin == nil {
+		config.PhoneLogin = c.PhoneLogin
+	}
+	if config.EmailFallback == nil {
+		config.EmailFallback = c.EmailFallback
+	}
+	if config.WhatsAppConsentPolicyVersion == "" {
+		config.WhatsAppConsentPolicyVersion = c.WhatsAppConsentPolicyVersion
+	}
+	return config
+}
+
+// NumberTypesFor returns the number types accepted for codes sent over method; method "" (no
+// code sent) uses AllowedNumberTypes. Empty allows all.
+func (c InstancePhoneConfig) NumberTypesFor(method string) []string {
+	if types, ok := c.AllowedNumberTypesByMethod[method]; ok {
+		return types
+	}
+	return c.AllowedNumberTypes
+}
+
+// PhoneLoginEnabled reports whether users can log in with a code sent to their phone.
+func (c InstancePhoneConfig) PhoneLoginEnabled() bool {
+	return c.PhoneLogin != nil && *c.PhoneLogin
+}
+
+// EmailFallbackEnabled reports whether failed phone deliveries fall back to an email link.
+func (c InstancePhoneConfig) EmailFallbackEnabled() bool {
+	return c.EmailFallback != nil && *c.EmailFallback
+}
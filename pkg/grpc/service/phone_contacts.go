@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const MAX_PHONE_NUMBERS_PER_USER = 3
+
+// Channels a phone contact can be reached by
+const (
+	PHONE_CHANNEL_WHATSAPP = "whatsapp"
+	PHONE_CHANNEL_SMS      = "sms"
+	PHONE_CHANNEL_VOICE    = "voice"
+)
+
+var phoneChannels = []string{PHONE_CHANNEL_WHATSAPP, PHONE_CHANNEL_SMS, PHONE_CHANNEL_VOICE}
+
+func (s *userManagementServer) ListPhoneNumbers(ctx context.Context, req *api.ListPhoneNumbersRequest) (*api.PhoneNumberList, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	return s.getPhoneNumberList(instanceID, userID)
+}
+
+func (s *userManagementServer) SetPrimaryPhoneNumber(ctx context.Context, req *api.SetPrimaryPhoneNumberRequest) (*api.PhoneNumberList, error) {
+	if req == nil || req.Token == "" || req.ContactId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	contact := findPhoneContact(user, req.ContactId)
+	if contact == nil {
+		return nil, status.Error(codes.NotFound, "phone number not found")
+	}
+	// Messages and codes go to the primary number, so moving them off a confirmed one needs the
+	// same re-authentication as removing it
+	if current := primaryPhoneContact(user); current != nil && current.ID != contact.ID && current.ConfirmedAt > 0 {
+		if err := checkPhoneChangeReauth(instanceID, user, req.Token, req.Password, req.ClientIp); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.userDBservice.SetPrimaryPhoneNumber(instanceID, userID, req.ContactId); err != nil {
+		log.Printf("Error setting primary phone number: %v", err)
+		return nil, status.Error(codes.Internal, "failed to set primary phone number")
+	}
+	return s.getPhoneNumberList(instanceID, userID)
+}
+
+func (s *userManagementServer) SetPhoneNumberChannels(ctx context.Context, req *api.SetPhoneNumberChannelsRequest) (*api.PhoneNumberList, error) {
+	if req == nil || req.Token == "" || req.ContactId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	for _, channel := range req.Channels {
+		if !containsString(phoneChannels, channel) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown channel: %s", channel)
+		}
+	}
+
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if findPhoneContact(user, req.ContactId) == nil {
+		return nil, status.Error(codes.NotFound, "phone number not found")
+	}
+
+	if err := s.userDBservice.SetPhoneNumberChannels(instanceID, userID, req.ContactId, req.Channels); err != nil {
+		log.Printf("Error updating phone number channels: %v", err)
+		return nil, status.Error(codes.Internal, "failed to update phone number channels")
+	}
+	return s.getPhoneNumberList(instanceID, userID)
+}
+
+func (s *userManagementServer) getPhoneNumberList(instanceID, userID string) (*api.PhoneNumberList, error) {
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	consents, err := s.userDBservice.GetMessagingConsents(instanceID, userID)
+	if err != nil {
+		log.Printf("Error reading messaging consents: %v", err)
+	}
+
+	reverifyAfterDays := phoneVerificationConfig.ForInstance(instanceID).ReverifyAfterDays
+	now := time.Now()
+
+	list := &api.PhoneNumberList{}
+	for _, contact := range phoneContacts(user) {
+		list.PhoneNumbers = append(list.PhoneNumbers, &api.PhoneNumberInfo{
+			ContactId:         contact.ID.Hex(),
+			Phone:             contact.Phone,
+			Primary:           contact.Primary,
+			Channels:          contact.Channels,
+			ConfirmedAt:       contact.ConfirmedAt,
+			VerifiedVia:       contact.VerifiedVia,
+			LastVerifiedAt:    phoneLastVerifiedAt(contact),
+			ReverificationDue: phoneReverificationDue(contact, reverifyAfterDays, now),
+			WhatsappConsent:   activeMessagingConsent(consents, PHONE_CHANNEL_WHATSAPP, contact.Phone) != nil,
+		})
+	}
+	return list, nil
+}
+
+// ensurePrimaryPhoneNumber makes the first confirmed (or else the first) phone contact primary if
+// the user has phones but none of them is primary, e.g. after adding the first one or removing
+// the primary one.
+func (s *userManagementServer) ensurePrimaryPhoneNumber(instanceID, userID string) error {
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return err
+	}
+	phones := phoneContacts(user)
+	if len(phones) == 0 || primaryPhoneContact(user) != nil {
+		return nil
+	}
+	for _, contact := range phones {
+		if contact.ConfirmedAt > 0 {
+			return s.userDBservice.SetPrimaryPhoneNumber(instanceID, userID, contact.ID.Hex())
+		}
+	}
+	return s.userDBservice.SetPrimaryPhoneNumber(instanceID, userID, phones[0].ID.Hex())
+}
+
+func phoneContacts(user models.User) []models.ContactInfo {
+	phones := []models.ContactInfo{}
+	for _, contact := range user.ContactInfos {
+		if contact.Type == "phone" {
+			phones = append(phones, contact)
+		}
+	}
+	return phones
+}
+
+func findPhoneContact(user models.User, contactID string) *models.ContactInfo {
+	for i, contact := range user.ContactInfos {
+		if contact.Type == "phone" && contact.ID.Hex() == contactID {
+			return &user.ContactInfos[i]
+		}
+	}
+	return nil
+}
+
+func findPhoneContactByNumber(user models.User, phoneNumber string) *models.ContactInfo {
+	for i, contact := range user.ContactInfos {
+		if contact.Type == "phone" && contact.Phone == phoneNumber {
+			return &user.ContactInfos[i]
+		}
+	}
+	return nil
+}
+
+// primaryPhoneContact returns the phone contact messages should be sent to, or nil.
+func primaryPhoneContact(user models.User) *models.ContactInfo {
+	for i, contact := range user.ContactInfos {
+		if contact.Type == "phone" && contact.Primary {
+			return &user.ContactInfos[i]
+		}
+	}
+	return nil
+}
+
+// phoneChannelAllowed reports whether contact may be reached over channel. Unverified contacts
+// are never reached, contacts without explicit channels accept all of them.
+func phoneChannelAllowed(contact models.ContactInfo, channel string) bool {
+	if contact.ConfirmedAt <= 0 {
+		return false
+	}
+	return len(contact.Channels) == 0 || containsString(contact.Channels, channel)
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Method of attempts whose code could not be sent to the phone: the user got an email link and
+// sends the code to our WhatsApp business number instead.
+const VERIFICATION_METHOD_EMAIL_LINK = "email-link"
+
+const EMAIL_TYPE_PHONE_VERIFICATION_FALLBACK = "phone-verification-fallback"
+
+// Business number (E.164) users send the code to in the reverse flow, unless set per instance
+// in the WhatsApp config
+const ENV_WHATSAPP_BUSINESS_PHONE_NUMBER = "WHATSAPP_BUSINESS_PHONE_NUMBER"
+
+// Time left to open the email link and send the code, counted from the fallback
+const EMAIL_FALLBACK_EXPIRY = 30 * time.Minute
+
+// Prefix of the message sent to the business number, so it can be told apart from other messages
+const REVERSE_VERIFICATION_MESSAGE_PREFIX = "InfluenzaNet"
+
+// sendWithFallback sends the code of the attempt to its phone number. If WhatsApp fails and the
+// instance allows it, an email link to the reverse flow is sent to the account's confirmed
+// address and attempt.Method is updated to it. WhatsApp is skipped for numbers without consent.
+// There is no SMS step until an SMS provider sends the codes.
+func (s *userManagementServer) sendWithFallback(attempt *VerificationAttempt) error {
+	err := errNoWhatsAppConsent
+	if attempt.Method != PHONE_CHANNEL_WHATSAPP || s.whatsAppAllowedForAttempt(attempt, attempt.PhoneNumber) {
+		err = s.sendVerificationCode(attempt.InstanceID, attempt.PhoneNumber, attempt.Code, attempt.Method, attempt.Language, 0)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if attempt.Method != PHONE_CHANNEL_WHATSAPP {
+		return err
+	}
+	if attempt.isLoginChallenge() || !phoneVerificationConfig.ForInstance(attempt.InstanceID).EmailFallbackEnabled() {
+		return err
+	}
+
+	log.Printf("Phone verification failed, falling back to email link: %v", err)
+	if fallbackErr := s.sendEmailFallbackLink(attempt); fallbackErr != nil {
+		log.Printf("Email fallback failed: %v", fallbackErr)
+		return err
+	}
+	attempt.Method = VERIFICATION_METHOD_EMAIL_LINK
+	return nil
+}
+
+// sendEmailFallbackLink emails the link to the reverse flow to the confirmed account address.
+func (s *userManagementServer) sendEmailFallbackLink(attempt *VerificationAttempt) error {
+	if attempt.FallbackToken == "" {
+		user, err := s.userDBservice.GetUser(attempt.InstanceID, attempt.UserID)
+		if err != nil {
+			return err
+		}
+		if user.Account.AccountConfirmedAt <= 0 || user.Account.AccountID == "" {
+			return fmt.Errorf("account email not confirmed")
+		}
+
+		bytes := make([]byte, 24)
+		if _, err := rand.Read(bytes); err != nil {
+			return err
+		}
+		attempt.FallbackToken = hex.EncodeToString(bytes)
+		attempt.FallbackEmail = user.Account.AccountID
+		attempt.FallbackLanguage = user.Account.PreferredLanguage
+		if expiresAt := time.Now().Add(EMAIL_FALLBACK_EXPIRY); expiresAt.After(attempt.ExpiresAt) {
+			attempt.ExpiresAt = expiresAt
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:  attempt.InstanceID,
+		To:          []string{attempt.FallbackEmail},
+		MessageType: EMAIL_TYPE_PHONE_VERIFICATION_FALLBACK,
+		ContentInfos: map[string]string{
+			"token":            attempt.FallbackToken,
+			"phoneNumber":      maskPhoneNumber(attempt.PhoneNumber),
+			"expiresInMinutes": fmt.Sprintf("%d", int(time.Until(attempt.ExpiresAt).Minutes())),
+		},
+		PreferredLanguage: attempt.FallbackLanguage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send fallback email: %w", err)
+	}
+	return nil
+}
+
+// StartReverseVerification is called from the email fallback link. It returns the message the
+// user has to send from the phone being verified to our WhatsApp business number; receiving it
+// proves possession of the number.
+func (s *userManagementServer) StartReverseVerification(ctx context.Context, req *api.StartReverseVerificationRequest) (*api.ReverseVerificationInstructions, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	attempt := findFallbackAttempt(req.Token)
+	if attempt == nil {
+		return nil, status.Error(codes.NotFound, "invalid or expired link")
+	}
+
+	businessNumber := whatsAppConfig.ForInstance(attempt.InstanceID).BusinessPhoneNumber
+	if businessNumber == "" {
+		log.Printf("No WhatsApp business number configured for %s, reverse verification unavailable", attempt.InstanceID)
+		return nil, status.Error(codes.Unavailable, "reverse verification not available")
+	}
+
+	// A code of its own: anyone who saw the email must not be able to use it elsewhere
+	reverseCode, err := s.generateVerificationCode()
+	if err != nil {
+		log.Printf("Error generating verification code: %v", err)
+		return nil, status.Error(codes.Internal, "failed to generate verification code")
+	}
+	attempt, exists := verificationAttempts.update(attempt.Token, func(attempt *VerificationAttempt) {
+		attempt.AwaitingReverseMessage = true
+		if attempt.ReverseCode == "" {
+			attempt.ReverseCode = reverseCode
+		}
+	})
+	if !exists {
+		return nil, status.Error(codes.NotFound, "invalid or expired link")
+	}
+	return reverseVerificationInstructions(businessNumber, attempt.PhoneNumber, attempt.ReverseCode, attempt.ExpiresAt), nil
+}
+
+// reverseVerificationInstructions returns what the website shows for code to be sent from
+// phoneNumber to the business number.
+func reverseVerificationInstructions(businessNumber, phoneNumber, code string, expiresAt time.Time) *api.ReverseVerificationInstructions {
+	messageText := reverseVerificationMessage(code)
+	return &api.ReverseVerificationInstructions{
+		BusinessPhoneNumber: businessNumber,
+		MessageText:         messageText,
+		WhatsappLink:        fmt.Sprintf("https://wa.me/%s?text=%s", strings.TrimPrefix(businessNumber, "+"), url.QueryEscape(messageText)),
+		MaskedPhoneNumber:   maskPhoneNumber(phoneNumber),
+		ExpiresAt:           expiresAt.Unix(),
+	}
+}
+
+// isReverseStep tells whether the current code of the attempt is shown on the website, to be
+// sent from the phone to the business number, rather than sent to the user. The email proof
+// of the current number still takes a code sent by email.
+func (attempt *VerificationAttempt) isReverseStep() bool {
+	return attempt.Method == VERIFICATION_METHOD_WHATSAPP_REVERSE && attempt.codeRecipient() != ""
+}
+
+// setCode sets the code of the current step of the attempt. A code shown on the website is kept
+// apart, so that it can never be entered on the website instead of being sent from the phone.
+func (attempt *VerificationAttempt) setCode(code string) {
+	if attempt.isReverseStep() {
+		attempt.Code = ""
+		attempt.ReverseCode = code
+		return
+	}
+	attempt.Code = code
+}
+
+// expectedReverseCode returns the code a message from the phone to the business number must
+// contain: the code shown on the website for the email fallback and the reverse method, the
+// code sent otherwise.
+func (attempt *VerificationAttempt) expectedReverseCode() string {
+	if attempt.Method == VERIFICATION_METHOD_EMAIL_LINK || attempt.isReverseStep() {
+		return attempt.ReverseCode
+	}
+	return attempt.Code
+}
+
+// reverseInstructions returns the instructions to show on the website for the current step of
+// the attempt, or nil if its code is sent to the user.
+func (attempt *VerificationAttempt) reverseInstructions() *api.ReverseVerificationInstructions {
+	if !attempt.isReverseStep() {
+		return nil
+	}
+	businessNumber := whatsAppConfig.ForInstance(attempt.InstanceID).BusinessPhoneNumber
+	return reverseVerificationInstructions(businessNumber, attempt.codeRecipient(), attempt.ReverseCode, attempt.ExpiresAt)
+}
+
+// reverseVerificationMessage returns the text the user sends to the business number.
+func reverseVerificationMessage(code string) string {
+	return REVERSE_VERIFICATION_MESSAGE_PREFIX + " " + code
+}
+
+// findFallbackAttempt returns the pending, not expired attempt the email link token belongs to.
+func findFallbackAttempt(token string) *VerificationAttempt {
+	return verificationAttempts.findOne(func(attempt *VerificationAttempt) bool {
+		return attempt.FallbackToken != "" && attempt.Status == "pending" &&
+			subtle.ConstantTimeCompare([]byte(attempt.FallbackToken), []byte(token)) == 1 &&
+			time.Now().Before(attempt.ExpiresAt)
+	})
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	LOG_EVENT_PHONE_LOGIN        = "PHONE_LOGIN"
+	LOG_EVENT_PHONE_LOGIN_FAILED = "PHONE_LOGIN_FAILED"
+)
+
+// RequestPhoneLoginCode sends a one-time login code to a verified phone number. To not reveal
+// which numbers are registered, unknown numbers get the same response, but no code is sent.
+func (s *userManagementServer) RequestPhoneLoginCode(ctx context.Context, req *api.PhoneLoginCodeRequest) (*api.PhoneLoginCodeResponse, error) {
+	if req == nil || req.InstanceId == "" || req.PhoneNumber == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !phoneVerificationConfig.ForInstance(req.InstanceId).PhoneLoginEnabled() {
+		return nil, status.Error(codes.FailedPrecondition, "phone login is not enabled")
+	}
+
+	phoneNumber, err := s.validatePhoneNumber(req.InstanceId, req.PhoneNumber, req.VerificationMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt, err := s.startPhoneVerification(&VerificationAttempt{
+		UserID:      s.findPhoneLoginUser(req.InstanceId, phoneNumber.E164()),
+		InstanceID:  req.InstanceId,
+		Purpose:     VERIFICATION_PURPOSE_LOGIN,
+		PhoneNumber: phoneNumber.E164(),
+		Method:      req.VerificationMethod,
+		Language:    req.Language,
+	}, req.ClientIp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.PhoneLoginCodeResponse{
+		Success:            true,
+		VerificationToken:  attempt.Token,
+		VerificationMethod: attempt.Method,
+		MaskedPhoneNumber:  maskPhoneNumber(attempt.PhoneNumber),
+		ExpiresAt:          attempt.ExpiresAt.Unix(),
+		AttemptsRemaining:  int32(attempt.MaxAttempts - attempt.Attempts),
+	}, nil
+}
+
+// LoginWithPhoneCode exchanges a login code for access and refresh tokens. Accounts with the
+// phone second factor must log in with their password.
+func (s *userManagementServer) LoginWithPhoneCode(ctx context.Context, req *api.PhoneLoginRequest) (*api.TokenResponse, error) {
+	if req == nil || req.InstanceId == "" || req.VerificationToken == "" || req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !phoneVerificationConfig.ForInstance(req.InstanceId).PhoneLoginEnabled() {
+		return nil, status.Error(codes.FailedPrecondition, "phone login is not enabled")
+	}
+
+	attempt, exists := verificationAttempts.get(req.VerificationToken)
+	if !exists || attempt.Purpose != VERIFICATION_PURPOSE_LOGIN || attempt.InstanceID != req.InstanceId {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired verification token")
+	}
+	attempt, attemptStatus := verificationAttempts.countGuess(req.VerificationToken, time.Now())
+	if attemptStatus != "pending" {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired verification token")
+	}
+
+	if attempt.UserID == "" || !codeMatches(attempt.Code, req.Code) {
+		if attempt.Attempts >= attempt.MaxAttempts {
+			verificationAttempts.remove(req.VerificationToken)
+		}
+		if attempt.UserID != "" {
+			s.SaveLogEvent(attempt.InstanceID, attempt.UserID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_PHONE_LOGIN_FAILED, maskPhoneNumber(attempt.PhoneNumber))
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid verification code")
+	}
+	verificationAttempts.remove(req.VerificationToken)
+
+	user, err := s.userDBservice.GetUser(attempt.InstanceID, attempt.UserID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// The number may have been removed since the code was sent
+	contact := findPhoneContactByNumber(user, attempt.PhoneNumber)
+	if contact == nil || contact.ConfirmedAt <= 0 {
+		return nil, status.Error(codes.Unauthenticated, "invalid verification code")
+	}
+	// The second factor may have been enabled since the code was sent
+	if s.phoneSecondFactorEnabled(attempt.InstanceID, attempt.UserID) {
+		return nil, status.Error(codes.FailedPrecondition, "second factor enabled: log in with your password")
+	}
+
+	// Receiving the code proves the number still belongs to the user
+	if err := s.userDBservice.ConfirmPhoneNumber(attempt.InstanceID, attempt.UserID, contact.ID.Hex(), attempt.Method); err != nil {
+		log.Printf("Error updating phone verification time: %v", err)
+	}
+
+	response, err := s.issueLoginTokens(attempt.InstanceID, user)
+	if err != nil {
+		log.Printf("Error generating tokens for phone login: %v", err)
+		return nil, status.Error(codes.Internal, "failed to generate tokens")
+	}
+	if err := s.userDBservice.UpdateLoginTime(attempt.InstanceID, attempt.UserID); err != nil {
+		log.Printf("Error updating login time: %v", err)
+	}
+
+	s.SaveLogEvent(attempt.InstanceID, attempt.UserID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_PHONE_LOGIN, maskPhoneNumber(attempt.PhoneNumber))
+	return response, nil
+}
+
+// findPhoneLoginUser returns the ID of the user phoneNumber is a confirmed contact of, or "" if
+// there is none, it is shared by several accounts, the account is not confirmed or it has the
+// phone second factor enabled: a code sent to the phone alone must not log such accounts in.
+func (s *userManagementServer) findPhoneLoginUser(instanceID, phoneNumber string) string {
+	userIDs, err := s.userDBservice.FindUserIDsByPhoneNumber(instanceID, phoneNumber)
+	if err != nil {
+		log.Printf("Error looking up phone number for login: %v", err)
+		return ""
+	}
+	if len(userIDs) != 1 {
+		if len(userIDs) > 1 {
+			log.Printf("Phone login refused: number shared by %d accounts in instance %s", len(userIDs), instanceID)
+		}
+		return ""
+	}
+
+	user, err := s.userDBservice.GetUser(instanceID, userIDs[0])
+	if err != nil || user.Account.AccountConfirmedAt <= 0 {
+		return ""
+	}
+	if s.phoneSecondFactorEnabled(instanceID, userIDs[0]) {
+		return ""
+	}
+	return userIDs[0]
+}
+
+// phoneSecondFactorEnabled reports whether the user has the phone second factor enabled. Errors
+// count as enabled, so they cannot be used to skip the password.
+func (s *userManagementServer) phoneSecondFactorEnabled(instanceID, userID string) bool {
+	secondFactor, err := s.userDBservice.GetPhoneSecondFactor(instanceID, userID)
+	if err != nil {
+		log.Printf("Error loading second factor of user %s: %v", userID, err)
+		return true
+	}
+	return secondFactor.Enabled
+}
+
+// issueLoginTokens generates an access token for the main profile of user and stores a new
+// refresh token.
+func (s *userManagementServer) issueLoginTokens(instanceID string, user models.User) (*api.TokenResponse, error) {
+	mainProfileID := ""
+	otherProfileIDs := []string{}
+	for _, profile := range user.Profiles {
+		if mainProfileID == "" && profile.MainProfile {
+			mainProfileID = profile.ID.Hex()
+		} else {
+			otherProfileIDs = append(otherProfileIDs, profile.ID.Hex())
+		}
+	}
+	if mainProfileID == "" && len(otherProfileIDs) > 0 {
+		mainProfileID, otherProfileIDs = otherProfileIDs[0], otherProfileIDs[1:]
+	}
+
+	accessToken, err := tokens.GenerateNewToken(
+		user.ID.Hex(),
+		user.Account.AccountConfirmedAt > 0,
+		mainProfileID,
+		user.Roles,
+		instanceID,
+		s.Intervals.TokenExpiryInterval,
+		"",
+		nil,
+		otherProfileIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := tokens.GenerateUniqueTokenString()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userDBservice.AddRefreshToken(instanceID, user.ID.Hex(), refreshToken); err != nil {
+		return nil, err
+	}
+
+	return &api.TokenResponse{
+		AccessToken:       accessToken,
+		RefreshToken:      refreshToken,
+		ExpiresIn:         int32(s.Intervals.TokenExpiryInterval / time.Minute),
+		SelectedProfileId: mainProfileID,
+		PreferredLanguage: user.Account.PreferredLanguage,
+	}, nil
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	EMAIL_TYPE_PHONE_NUMBER_CHANGED   = "phone-number-changed"
+	TOKEN_PURPOSE_REVOKE_PHONE_CHANGE = "revoke_phone_change"
+	LOG_EVENT_PHONE_CHANGE_REVOKED    = "PHONE_CHANGE_REVOKED"
+)
+
+// How long the "this wasn't me" link of a notification stays valid
+const PHONE_CHANGE_REVOKE_TOKEN_EXPIRY = 7 * 24 * time.Hour
+
+// Kinds of phone changes a notification is sent for
+const (
+	PHONE_CHANGE_ADDED   = "added"
+	PHONE_CHANGE_CHANGED = "changed"
+	PHONE_CHANGE_REMOVED = "removed"
+)
+
+// Security notices sent by phone to users who chose it for account security messages
+var phoneChangeNoticeTexts = map[string]string{
+	PHONE_CHANGE_ADDED:   "A phone number was added to your InfluenzaNet account. If this wasn't you, use the link in the email we sent you.",
+	PHONE_CHANGE_CHANGED: "The phone number of your InfluenzaNet account was changed. If this wasn't you, use the link in the email we sent you.",
+	PHONE_CHANGE_REMOVED: "A phone number was removed from your InfluenzaNet account. If this wasn't you, use the link in the email we sent you.",
+}
+
+// notifyPhoneNumberChange emails the account owner about a phone change, with a link revoking
+// it, and sends a notice by phone if they chose so. previous is the contact as it was before a
+// PHONE_CHANGE_CHANGED or PHONE_CHANGE_REMOVED, kept in the revoke token to restore it. Failures
+// are only logged: the change itself already happened. Called in the background, so the
+// response to the change does not wait for the email.
+func (s *userManagementServer) notifyPhoneNumberChange(instanceID, userID, change, contactID, oldPhone, newPhone string, previous *models.ContactInfo) {
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		log.Printf("Phone change notification: cannot load user %s: %v", userID, err)
+		return
+	}
+
+	info := map[string]string{
+		"change":    change,
+		"contactId": contactID,
+		"oldPhone":  oldPhone,
+		"newPhone":  newPhone,
+	}
+	if previous != nil {
+		info["confirmedAt"] = strconv.FormatInt(previous.ConfirmedAt, 10)
+		info["verifiedVia"] = previous.VerifiedVia
+		info["lastVerifiedAt"] = strconv.FormatInt(previous.LastVerifiedAt, 10)
+		info["channels"] = strings.Join(previous.Channels, ",")
+	}
+
+	changedAt := time.Now()
+	revokeToken, err := s.globalDBService.AddTempToken(models.TempToken{
+		UserID:     userID,
+		InstanceID: instanceID,
+		Purpose:    TOKEN_PURPOSE_REVOKE_PHONE_CHANGE,
+		Info:       info,
+		Expiration: changedAt.Add(PHONE_CHANGE_REVOKE_TOKEN_EXPIRY).Unix(),
+	})
+	if err != nil {
+		log.Printf("Phone change notification: cannot create revoke token: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:  instanceID,
+		To:          []string{user.Account.AccountID},
+		MessageType: EMAIL_TYPE_PHONE_NUMBER_CHANGED,
+		ContentInfos: map[string]string{
+			"change":         change,
+			"oldPhoneNumber": maskPhoneNumber(oldPhone),
+			"newPhoneNumber": maskPhoneNumber(newPhone),
+			"changedAt":      changedAt.UTC().Format(time.RFC3339),
+			"token":          revokeToken,
+		},
+		PreferredLanguage: user.Account.PreferredLanguage,
+	})
+	if err != nil {
+		log.Printf("Phone change notification: cannot send email to user %s: %v", userID, err)
+	}
+
+	s.notifySecurityEventByPhone(instanceID, user, phoneChangeNoticeTexts[change])
+}
+
+// RevokePhoneChange undoes the phone change a notification email was sent for. The token of the
+// "this wasn't me" link is the only credential, so it can be used without being logged in.
+func (s *userManagementServer) RevokePhoneChange(ctx context.Context, req *api.RevokePhoneChangeRequest) (*api.RevokePhoneChangeResponse, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	tokenInfos, err := s.globalDBService.GetTempToken(req.Token)
+	if err != nil || tokenInfos.Purpose != TOKEN_PURPOSE_REVOKE_PHONE_CHANGE {
+		return nil, status.Error(codes.InvalidArgument, "invalid token")
+	}
+	if time.Now().Unix() > tokenInfos.Expiration {
+		return nil, status.Error(codes.InvalidArgument, "token expired")
+	}
+
+	instanceID, userID := tokenInfos.InstanceID, tokenInfos.UserID
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	contactID, oldPhone, newPhone := tokenInfos.Info["contactId"], tokenInfos.Info["oldPhone"], tokenInfos.Info["newPhone"]
+	change := tokenInfos.Info["change"]
+	switch change {
+	case PHONE_CHANGE_ADDED, PHONE_CHANGE_CHANGED:
+		// The contact must still hold the number the notification was sent for
+		contact := findPhoneContact(user, contactID)
+		if contact == nil || contact.Phone != newPhone {
+			return nil, status.Error(codes.FailedPrecondition, "phone number was already changed or removed")
+		}
+		if change == PHONE_CHANGE_ADDED {
+			err = s.removeRevokedPhoneNumber(instanceID, userID, contact)
+		} else {
+			err = s.userDBservice.RevertPhoneNumber(instanceID, userID, newPhone, previousPhoneContact(tokenInfos.Info))
+		}
+	case PHONE_CHANGE_REMOVED:
+		if findPhoneContactByNumber(user, oldPhone) != nil {
+			return nil, status.Error(codes.FailedPrecondition, "phone number was already added again")
+		}
+		err = s.userDBservice.RestorePhoneNumber(instanceID, userID, previousPhoneContact(tokenInfos.Info))
+	default:
+		return nil, status.Error(codes.InvalidArgument, "invalid token")
+	}
+	if err != nil {
+		log.Printf("Error revoking phone change: %v", err)
+		return nil, status.Error(codes.FailedPrecondition, "phone change cannot be revoked anymore")
+	}
+
+	// The number the owner did not add must not keep receiving WhatsApp messages
+	if change == PHONE_CHANGE_ADDED || change == PHONE_CHANGE_CHANGED {
+		if err := s.revokeWhatsAppConsent(instanceID, userID, newPhone, CONSENT_SOURCE_PHONE_REMOVED); err != nil {
+			log.Printf("Error revoking WhatsApp consent: %v", err)
+		}
+		if err := s.resetUnreachableNotificationChannels(instanceID, userID); err != nil {
+			log.Printf("Error updating notification preferences: %v", err)
+		}
+	}
+
+	if err := s.globalDBService.DeleteTempToken(req.Token); err != nil {
+		log.Printf("Error deleting revoke token: %v", err)
+	}
+
+	// Whoever made the change must not be able to complete a pending verification either
+	verificationAttempts.removeWhere(func(attempt *VerificationAttempt) bool {
+		return attempt.UserID == userID && attempt.InstanceID == instanceID
+	})
+
+	if err := s.ensurePrimaryPhoneNumber(instanceID, userID); err != nil {
+		log.Printf("Error setting primary phone number: %v", err)
+	}
+
+	s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_PHONE_CHANGE_REVOKED, change)
+
+	return &api.RevokePhoneChangeResponse{
+		Success: true,
+		Message: "Phone change revoked",
+	}, nil
+}
+
+// removeRevokedPhoneNumber removes a phone contact the owner did not add. Unlike
+// RemovePhoneNumber it does not refuse the second factor number: whoever added the number may
+// have made it the second factor too, so the second factor is disabled with it.
+func (s *userManagementServer) removeRevokedPhoneNumber(instanceID, userID string, contact *models.ContactInfo) error {
+	secondFactor, err := s.userDBservice.GetPhoneSecondFactor(instanceID, userID)
+	if err != nil {
+		return err
+	}
+	if secondFactor.Enabled && secondFactor.ContactID == contact.ID {
+		if err := s.userDBservice.RemovePhoneSecondFactor(instanceID, userID); err != nil {
+			return err
+		}
+		log.Printf("Phone second factor of user %s disabled by a revoked phone change", userID)
+	}
+	return s.userDBservice.RemovePhoneNumber(instanceID, userID, contact.ID.Hex())
+}
+
+// previousPhoneContact rebuilds the contact as it was before the change a PHONE_CHANGE_CHANGED or
+// PHONE_CHANGE_REMOVED revoke token was created for.
+func previousPhoneContact(info map[string]string) models.ContactInfo {
+	contactID, _ := primitive.ObjectIDFromHex(info["contactId"])
+	confirmedAt, _ := strconv.ParseInt(info["confirmedAt"], 10, 64)
+	lastVerifiedAt, _ := strconv.ParseInt(info["lastVerifiedAt"], 10, 64)
+	contact := models.ContactInfo{
+		ID:             contactID,
+		Type:           "phone",
+		Phone:          info["oldPhone"],
+		ConfirmedAt:    confirmedAt,
+		VerifiedVia:    info["verifiedVia"],
+		LastVerifiedAt: lastVerifiedAt,
+	}
+	if info["channels"] != "" {
+		contact.Channels = strings.Split(info["channels"], ",")
+	}
+	return contact
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"log"
+	"strings"
+
+	"github.com/influenzanet/user-management-service/pkg/phone"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons attached as ErrorInfo to InvalidArgument errors of phone validation, so clients can
+// show a localized message.
+const (
+	PHONE_ERROR_DOMAIN = "user-management-service"
+
+	PHONE_REASON_INVALID             = "PHONE_INVALID"
+	PHONE_REASON_COUNTRY_NOT_ALLOWED = "PHONE_COUNTRY_NOT_ALLOWED"
+	PHONE_REASON_PREFIX_BLOCKED      = "PHONE_PREFIX_BLOCKED"
+	PHONE_REASON_TYPE_NOT_ALLOWED    = "PHONE_TYPE_NOT_ALLOWED"
+	PHONE_REASON_ALREADY_IN_USE      = "PHONE_ALREADY_IN_USE"
+)
+
+// Values of the phoneUniqueness setting
+const (
+	PHONE_UNIQUENESS_ALLOW  = "allow"
+	PHONE_UNIQUENESS_WARN   = "warn"
+	PHONE_UNIQUENESS_REJECT = "reject"
+)
+
+// validatePhoneNumber parses phoneNumber and checks it against the phone policy of the instance,
+// with the number types accepted for codes sent over method.
+func (s *userManagementServer) validatePhoneNumber(instanceID, phoneNumber, method string) (phone.Number, error) {
+	config := phoneVerificationConfig.ForInstance(instanceID)
+
+	number, err := phone.Parse(phoneNumber, config.DefaultRegion)
+	if err != nil {
+		return phone.Number{}, phoneValidationError(PHONE_REASON_INVALID, "phone not valid")
+	}
+
+	if len(config.AllowedCountryCodes) > 0 && !containsString(config.AllowedCountryCodes, number.CountryCode) {
+		return phone.Number{}, phoneValidationError(PHONE_REASON_COUNTRY_NOT_ALLOWED, "phone country not allowed")
+	}
+
+	e164 := number.E164()
+	for _, prefix := range config.BlockedPrefixes {
+		if strings.HasPrefix(e164, prefix) {
+			return phone.Number{}, phoneValidationError(PHONE_REASON_PREFIX_BLOCKED, "phone prefix not allowed")
+		}
+	}
+
+	if err := checkNumberType(config, number, method); err != nil {
+		return phone.Number{}, err
+	}
+	return number, nil
+}
+
+// checkNumberType rejects number if its type cannot receive codes sent over method, e.g. a
+// fixed-line number for WhatsApp while it can take a voice call. Numbers that may be mobile
+// (fixed-line-or-mobile, e.g. all US and CA numbers) are accepted wherever mobile is.
+func checkNumberType(config InstancePhoneConfig, number phone.Number, method string) error {
+	allowedTypes := config.NumberTypesFor(method)
+	if len(allowedTypes) == 0 || containsString(allowedTypes, string(number.Type)) {
+		return nil
+	}
+	if number.Type == phone.TypeFixedLineOrMobile && containsString(allowedTypes, string(phone.TypeMobile)) {
+		return nil
+	}
+	return phoneValidationError(PHONE_REASON_TYPE_NOT_ALLOWED, "phone number type not allowed")
+}
+
+// checkPhoneUniqueness applies the phoneUniqueness setting of the instance to phoneNumber (E.164),
+// ignoring the account of userID itself. With the "warn" setting, a number used by another
+// account is accepted and PHONE_REASON_ALREADY_IN_USE is returned as warning for the client.
+func (s *userManagementServer) checkPhoneUniqueness(instanceID, userID, phoneNumber string) (string, error) {
+	policy := phoneVerificationConfig.ForInstance(instanceID).PhoneUniqueness
+	if policy == "" || policy == PHONE_UNIQUENESS_ALLOW {
+		return "", nil
+	}
+
+	userIDs, err := s.userDBservice.FindUserIDsByPhoneNumber(instanceID, phoneNumber)
+	if err != nil {
+		log.Printf("Error looking up phone number owners: %v", err)
+		return "", status.Error(codes.Internal, "failed to check phone number")
+	}
+
+	for _, id := range userIDs {
+		if id == userID {
+			continue
+		}
+		if policy == PHONE_UNIQUENESS_REJECT {
+			return "", phoneError(codes.AlreadyExists, PHONE_REASON_ALREADY_IN_USE, "phone already in use")
+		}
+		log.Printf("Phone number of user %s (instance %s) is already used by user %s", userID, instanceID, id)
+		return PHONE_REASON_ALREADY_IN_USE, nil
+	}
+	return "", nil
+}
+
+func phoneValidationError(reason, msg string) error {
+	return phoneError(codes.InvalidArgument, reason, msg)
+}
+
+func phoneError(code codes.Code, reason, msg string) error {
+	st := status.New(code, msg)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: PHONE_ERROR_DOMAIN})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
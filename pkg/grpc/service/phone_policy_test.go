@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/influenzanet/user-management-service/pkg/phone"
+)
+
+func TestCheckNumberType(t *testing.T) {
+	config := InstancePhoneConfig{
+		AllowedNumberTypes: []string{string(phone.TypeMobile)},
+		AllowedNumberTypesByMethod: map[string][]string{
+			"voice": {string(phone.TypeFixedLine)},
+		},
+	}
+	tests := []struct {
+		name    string
+		raw     string
+		method  string
+		allowed bool
+	}{
+		{"mobile", "+41 79 123 45 67", "whatsapp", true},
+		{"fixed line", "+39 06 1234 5678", "whatsapp", false},
+		{"us number where mobile is allowed", "+1 212 555 0100", "whatsapp", true},
+		{"ca number where mobile is allowed", "+1 416 555 0100", "", true},
+		{"fixed line for voice", "+39 06 1234 5678", "voice", true},
+		{"us number where only fixed lines are allowed", "+1 212 555 0100", "voice", false},
+		{"toll free", "+41 800 123 456", "sms", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			number, err := phone.Parse(tt.raw, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = checkNumberType(config, number, tt.method)
+			if (err == nil) != tt.allowed {
+				t.Errorf("expected allowed %v, got error %v", tt.allowed, err)
+			}
+		})
+	}
+
+	if err := checkNumberType(InstancePhoneConfig{}, phone.Number{Type: phone.TypePremiumRate}, "sms"); err != nil {
+		t.Errorf("expected all types to be allowed without a setting, got %v", err)
+	}
+}
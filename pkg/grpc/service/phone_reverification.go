@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	messageAPI "github.com/influenzanet/messaging-service/pkg/api/messaging_service"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	EMAIL_TYPE_PHONE_REVERIFICATION = "phone-reverification-required"
+	USER_ROLE_ADMIN                 = "ADMIN"
+)
+
+// addUnverifiedPhoneNumber stores phoneNumber without verifying it. It can be verified later
+// with RequestPhoneNumberVerification and is not used to reach the user until then.
+func (s *userManagementServer) addUnverifiedPhoneNumber(instanceID, userID, phoneNumber string) (*api.AddPhoneNumberResponse, error) {
+	warning, err := s.checkPhoneUniqueness(instanceID, userID, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	contactID, err := s.userDBservice.AddUnverifiedPhoneNumber(instanceID, userID, phoneNumber)
+	if err != nil {
+		log.Printf("Error adding unverified phone number: %v", err)
+		return nil, status.Error(codes.Internal, "failed to add phone number")
+	}
+	if err := s.ensurePrimaryPhoneNumber(instanceID, userID); err != nil {
+		log.Printf("Error setting primary phone number: %v", err)
+	}
+
+	go s.notifyPhoneNumberChange(instanceID, userID, PHONE_CHANGE_ADDED, contactID, "", phoneNumber, nil)
+
+	return &api.AddPhoneNumberResponse{
+		Success:           true,
+		ContactId:         contactID,
+		MaskedPhoneNumber: maskPhoneNumber(phoneNumber),
+		Warning:           warning,
+	}, nil
+}
+
+// RequestPhoneNumberVerification sends a code to an existing phone contact, to confirm a number
+// added without verification or one due for re-verification.
+func (s *userManagementServer) RequestPhoneNumberVerification(ctx context.Context, req *api.RequestPhoneNumberVerificationRequest) (*api.AddPhoneNumberResponse, error) {
+	if req == nil || req.Token == "" || req.ContactId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	contact := findPhoneContact(user, req.ContactId)
+	if contact == nil {
+		return nil, status.Error(codes.NotFound, "phone number not found")
+	}
+
+	attempt, err := s.startPhoneVerification(&VerificationAttempt{
+		UserID:      userID,
+		InstanceID:  instanceID,
+		Purpose:     VERIFICATION_PURPOSE_VERIFY_PHONE,
+		PhoneNumber: contact.Phone,
+		ContactID:   req.ContactId,
+		Method:      req.VerificationMethod,
+		Language:    user.Account.PreferredLanguage,
+	}, req.ClientIp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.AddPhoneNumberResponse{
+		Success:             true,
+		ContactId:           req.ContactId,
+		VerificationToken:   attempt.Token,
+		VerificationMethod:  attempt.Method,
+		MaskedPhoneNumber:   maskPhoneNumber(attempt.PhoneNumber),
+		ExpiresAt:           attempt.ExpiresAt.Unix(),
+		ResendAvailableAt:   attempt.ResendAvailableAt.Unix(),
+		AttemptsRemaining:   int32(attempt.MaxAttempts - attempt.Attempts),
+		DeepLink:            attempt.deepLink(),
+		Warning:             attempt.PhoneWarning,
+		ReverseInstructions: attempt.reverseInstructions(),
+	}, nil
+}
+
+// phoneReverificationJobs tracks the instances whose re-verification emails are being sent, so
+// a job is not started twice for an instance.
+type phoneReverificationJobs struct {
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+var reverificationJobs = &phoneReverificationJobs{running: make(map[string]bool)}
+
+// start marks the job of instanceID as running. It reports false if it is already running.
+func (jobs *phoneReverificationJobs) start(instanceID string) bool {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	if jobs.running[instanceID] {
+		return false
+	}
+	jobs.running[instanceID] = true
+	return true
+}
+
+func (jobs *phoneReverificationJobs) finish(instanceID string) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	delete(jobs.running, instanceID)
+}
+
+// phoneReverificationNotice is the email of a user with phone numbers due for re-verification.
+type phoneReverificationNotice struct {
+	user          models.User
+	maskedNumbers []string
+}
+
+// StartPhoneReverification starts emailing every user of the admin's instance having a confirmed
+// phone number older than the configured reverifyAfterDays, asking them to verify it again. The
+// emails are sent in the background: the response counts the users they are sent to.
+func (s *userManagementServer) StartPhoneReverification(ctx context.Context, req *api.StartPhoneReverificationRequest) (*api.StartPhoneReverificationResponse, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	admin, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil || !admin.HasRole(USER_ROLE_ADMIN) {
+		return nil, status.Error(codes.PermissionDenied, "not permitted")
+	}
+
+	maxAgeDays := int(req.MaxAgeDays)
+	if maxAgeDays <= 0 {
+		maxAgeDays = phoneVerificationConfig.ForInstance(instanceID).ReverifyAfterDays
+	}
+	if maxAgeDays <= 0 {
+		return nil, status.Error(codes.FailedPrecondition, "phone re-verification is not configured")
+	}
+
+	if !reverificationJobs.start(instanceID) {
+		return nil, status.Error(codes.Aborted, "phone re-verification already running")
+	}
+
+	now := time.Now()
+	users, err := s.userDBservice.FindUsersWithPhoneVerifiedBefore(instanceID, now.AddDate(0, 0, -maxAgeDays).Unix())
+	if err != nil {
+		reverificationJobs.finish(instanceID)
+		log.Printf("Error looking up phone numbers due for re-verification: %v", err)
+		return nil, status.Error(codes.Internal, "failed to look up phone numbers")
+	}
+
+	response := &api.StartPhoneReverificationResponse{}
+	notices := []phoneReverificationNotice{}
+	for _, user := range users {
+		dueNumbers := []string{}
+		for _, contact := range phoneContacts(user) {
+			if phoneReverificationDue(contact, maxAgeDays, now) {
+				dueNumbers = append(dueNumbers, maskPhoneNumber(contact.Phone))
+			}
+		}
+		if len(dueNumbers) == 0 {
+			continue
+		}
+		response.PhoneNumbersDue += int32(len(dueNumbers))
+		notices = append(notices, phoneReverificationNotice{user: user, maskedNumbers: dueNumbers})
+	}
+	response.UsersNotified = int32(len(notices))
+
+	go s.sendPhoneReverificationEmails(instanceID, notices)
+
+	log.Printf("Phone re-verification started for instance %s: %d numbers due", instanceID, response.PhoneNumbersDue)
+	return response, nil
+}
+
+// sendPhoneReverificationEmails sends the emails of a re-verification job one after the other.
+func (s *userManagementServer) sendPhoneReverificationEmails(instanceID string, notices []phoneReverificationNotice) {
+	defer reverificationJobs.finish(instanceID)
+
+	failed := 0
+	for _, notice := range notices {
+		if !s.sendPhoneReverificationEmail(instanceID, notice.user, notice.maskedNumbers) {
+			failed++
+		}
+	}
+	log.Printf("Phone re-verification finished for instance %s: %d emails sent, %d failed", instanceID, len(notices)-failed, failed)
+}
+
+func (s *userManagementServer) sendPhoneReverificationEmail(instanceID string, user models.User, maskedNumbers []string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := s.clients.MessagingService.SendInstantEmail(ctx, &messageAPI.SendEmailReq{
+		InstanceId:  instanceID,
+		To:          []string{user.Account.AccountID},
+		MessageType: EMAIL_TYPE_PHONE_REVERIFICATION,
+		ContentInfos: map[string]string{
+			"phoneNumbers": strings.Join(maskedNumbers, ", "),
+		},
+		PreferredLanguage: user.Account.PreferredLanguage,
+		UseLowPrio:        true,
+	})
+	if err != nil {
+		log.Printf("Error sending phone re-verification email to user %s: %v", user.ID.Hex(), err)
+		return false
+	}
+	return true
+}
+
+// phoneLastVerifiedAt returns when contact was last verified, 0 if it never was.
+func phoneLastVerifiedAt(contact models.ContactInfo) int64 {
+	if contact.LastVerifiedAt > 0 {
+		return contact.LastVerifiedAt
+	}
+	return contact.ConfirmedAt
+}
+
+// phoneReverificationDue reports whether the confirmed contact was last verified more than
+// maxAgeDays ago. Unverified contacts are not due: they were never verified in the first place.
+func phoneReverificationDue(contact models.ContactInfo, maxAgeDays int, now time.Time) bool {
+	lastVerifiedAt := phoneLastVerifiedAt(contact)
+	if maxAgeDays <= 0 || lastVerifiedAt <= 0 {
+		return false
+	}
+	return lastVerifiedAt < now.AddDate(0, 0, -maxAgeDays).Unix()
+}
@@ -0,0 +1,364 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/pwhash"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	RECOVERY_CODE_COUNT    = 10
+	RECOVERY_CODE_LENGTH   = 10
+	RECOVERY_CODE_ALPHABET = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+const (
+	LOG_EVENT_SECOND_FACTOR_ENABLED        = "PHONE_SECOND_FACTOR_ENABLED"
+	LOG_EVENT_SECOND_FACTOR_DISABLED       = "PHONE_SECOND_FACTOR_DISABLED"
+	LOG_EVENT_SECOND_FACTOR_FAILED         = "PHONE_SECOND_FACTOR_FAILED"
+	LOG_EVENT_SECOND_FACTOR_RECOVERY_USED  = "PHONE_SECOND_FACTOR_RECOVERY_CODE_USED"
+	LOG_EVENT_SECOND_FACTOR_CODES_RENEWED  = "PHONE_SECOND_FACTOR_RECOVERY_CODES_RENEWED"
+	LOG_EVENT_SECOND_FACTOR_LOGIN_COMPLETE = "PHONE_SECOND_FACTOR_LOGIN"
+)
+
+// Channels a second factor code can be sent over, in order of preference. SMS is not offered
+// until an SMS provider sends the codes.
+var codeChannels = []string{PHONE_CHANNEL_WHATSAPP, PHONE_CHANNEL_VOICE}
+
+// EnablePhoneSecondFactor makes a code sent to a confirmed phone contact required at login. The
+// recovery codes are only returned by this call and RegenerateRecoveryCodes.
+func (s *userManagementServer) EnablePhoneSecondFactor(ctx context.Context, req *api.EnablePhoneSecondFactorRequest) (*api.RecoveryCodesResponse, error) {
+	if req == nil || req.Token == "" || req.ContactId == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	user, err := s.getUserWithPassword(instanceID, userID, req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	contact := findPhoneContact(user, req.ContactId)
+	if contact == nil {
+		return nil, status.Error(codes.NotFound, "phone number not found")
+	}
+	method := secondFactorChannel(*contact, req.VerificationMethod)
+	if method == "" {
+		return nil, status.Error(codes.FailedPrecondition, "phone number is not verified or cannot receive codes")
+	}
+
+	recoveryCodes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		return nil, status.Error(codes.Internal, "failed to generate recovery codes")
+	}
+
+	err = s.userDBservice.SetPhoneSecondFactor(instanceID, userID, models.PhoneSecondFactor{
+		Enabled:       true,
+		ContactID:     contact.ID,
+		Method:        method,
+		EnabledAt:     time.Now().Unix(),
+		RecoveryCodes: hashedCodes,
+	})
+	if err != nil {
+		log.Printf("Error enabling phone second factor: %v", err)
+		return nil, status.Error(codes.Internal, "failed to enable second factor")
+	}
+
+	s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_SECOND_FACTOR_ENABLED, maskPhoneNumber(contact.Phone))
+	return &api.RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *userManagementServer) DisablePhoneSecondFactor(ctx context.Context, req *api.DisablePhoneSecondFactorRequest) (*api.ServiceStatus, error) {
+	if req == nil || req.Token == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if _, err := s.getUserWithPassword(instanceID, userID, req.Password); err != nil {
+		return nil, err
+	}
+
+	if err := s.userDBservice.RemovePhoneSecondFactor(instanceID, userID); err != nil {
+		log.Printf("Error disabling phone second factor: %v", err)
+		return nil, status.Error(codes.Internal, "failed to disable second factor")
+	}
+
+	s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_SECOND_FACTOR_DISABLED, "")
+	return &api.ServiceStatus{
+		Status: api.ServiceStatus_NORMAL,
+		Msg:    "second factor disabled",
+	}, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not, with new ones.
+func (s *userManagementServer) RegenerateRecoveryCodes(ctx context.Context, req *api.RegenerateRecoveryCodesRequest) (*api.RecoveryCodesResponse, error) {
+	if req == nil || req.Token == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if _, err := s.getUserWithPassword(instanceID, userID, req.Password); err != nil {
+		return nil, err
+	}
+
+	secondFactor, err := s.userDBservice.GetPhoneSecondFactor(instanceID, userID)
+	if err != nil || !secondFactor.Enabled {
+		return nil, status.Error(codes.FailedPrecondition, "second factor not enabled")
+	}
+
+	recoveryCodes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		return nil, status.Error(codes.Internal, "failed to generate recovery codes")
+	}
+	secondFactor.RecoveryCodes = hashedCodes
+	if err := s.userDBservice.SetPhoneSecondFactor(instanceID, userID, secondFactor); err != nil {
+		log.Printf("Error storing recovery codes: %v", err)
+		return nil, status.Error(codes.Internal, "failed to store recovery codes")
+	}
+
+	s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_SECOND_FACTOR_CODES_RENEWED, "")
+	return &api.RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// completePasswordLogin is called by the password login (LoginWithEmail) once the password is
+// checked, to get what the login response carries: the tokens, or for users with the phone
+// second factor only a challenge, and no tokens until VerifySecondFactor completes it.
+func (s *userManagementServer) completePasswordLogin(instanceID string, user models.User, clientIP string) (*api.TokenResponse, *api.SecondFactorChallenge, error) {
+	challenge, err := s.startSecondFactorChallenge(instanceID, user, clientIP)
+	if err != nil {
+		return nil, nil, err
+	}
+	if challenge != nil {
+		return nil, challenge, nil
+	}
+
+	response, err := s.issueLoginTokens(instanceID, user)
+	if err != nil {
+		log.Printf("Error generating tokens for password login: %v", err)
+		return nil, nil, status.Error(codes.Internal, "failed to generate tokens")
+	}
+	if err := s.userDBservice.UpdateLoginTime(instanceID, user.ID.Hex()); err != nil {
+		log.Printf("Error updating login time: %v", err)
+	}
+	return response, nil, nil
+}
+
+// startSecondFactorChallenge sends a code to the phone of a user with the phone second factor
+// enabled and returns the challenge to complete with it; nil means no second factor is needed.
+func (s *userManagementServer) startSecondFactorChallenge(instanceID string, user models.User, clientIP string) (*api.SecondFactorChallenge, error) {
+	userID := user.ID.Hex()
+	secondFactor, err := s.userDBservice.GetPhoneSecondFactor(instanceID, userID)
+	if err != nil {
+		// Not knowing whether a second factor is needed must not skip it
+		log.Printf("Error loading second factor of user %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, "failed to check second factor")
+	}
+	if !secondFactor.Enabled {
+		return nil, nil
+	}
+
+	contact := findPhoneContact(user, secondFactor.ContactID.Hex())
+	method := ""
+	if contact != nil {
+		method = secondFactorChannel(*contact, secondFactor.Method)
+	}
+	if method == "" {
+		// The phone cannot be reached anymore: only a recovery code can complete the login
+		log.Printf("Second factor phone of user %s unavailable", userID)
+		return s.storeRecoveryOnlyChallenge(instanceID, userID)
+	}
+
+	attempt, err := s.startPhoneVerification(&VerificationAttempt{
+		UserID:      userID,
+		InstanceID:  instanceID,
+		Purpose:     VERIFICATION_PURPOSE_SECOND_FACTOR,
+		PhoneNumber: contact.Phone,
+		ContactID:   contact.ID.Hex(),
+		Method:      method,
+		Language:    user.Account.PreferredLanguage,
+	}, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.SecondFactorChallenge{
+		VerificationToken:  attempt.Token,
+		VerificationMethod: attempt.Method,
+		MaskedPhoneNumber:  maskPhoneNumber(attempt.PhoneNumber),
+		ExpiresAt:          attempt.ExpiresAt.Unix(),
+	}, nil
+}
+
+// storeRecoveryOnlyChallenge creates a challenge no code is sent for.
+func (s *userManagementServer) storeRecoveryOnlyChallenge(instanceID, userID string) (*api.SecondFactorChallenge, error) {
+	verificationToken, err := s.generateVerificationToken()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to generate verification token")
+	}
+
+	now := time.Now()
+	verificationAttempts.put(&VerificationAttempt{
+		UserID:      userID,
+		InstanceID:  instanceID,
+		Purpose:     VERIFICATION_PURPOSE_SECOND_FACTOR,
+		Token:       verificationToken,
+		MaxAttempts: MAX_VERIFICATION_ATTEMPTS,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute),
+		Status:      "pending",
+	})
+	return &api.SecondFactorChallenge{
+		VerificationToken: verificationToken,
+		ExpiresAt:         now.Add(VERIFICATION_CODE_EXPIRY_MINUTES * time.Minute).Unix(),
+	}, nil
+}
+
+// VerifySecondFactor completes a login challenged by startSecondFactorChallenge with the code
+// sent to the phone or a recovery code, and returns the login tokens.
+func (s *userManagementServer) VerifySecondFactor(ctx context.Context, req *api.VerifySecondFactorRequest) (*api.TokenResponse, error) {
+	if req == nil || req.InstanceId == "" || req.VerificationToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	attempt, exists := verificationAttempts.get(req.VerificationToken)
+	if !exists || attempt.Purpose != VERIFICATION_PURPOSE_SECOND_FACTOR || attempt.InstanceID != req.InstanceId {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired verification token")
+	}
+	attempt, attemptStatus := verificationAttempts.countGuess(req.VerificationToken, time.Now())
+	if attemptStatus != "pending" {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired verification token")
+	}
+
+	valid := false
+	if req.RecoveryCode != "" {
+		used, err := s.userDBservice.UsePhoneSecondFactorRecoveryCode(attempt.InstanceID, attempt.UserID, hashRecoveryCode(req.RecoveryCode))
+		if err != nil {
+			log.Printf("Error checking recovery code: %v", err)
+		}
+		valid = used
+		if valid {
+			s.SaveLogEvent(attempt.InstanceID, attempt.UserID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_SECOND_FACTOR_RECOVERY_USED, "")
+		}
+	} else {
+		valid = codeMatches(attempt.Code, req.Code)
+		if valid {
+			if err := s.userDBservice.ConfirmPhoneNumber(attempt.InstanceID, attempt.UserID, attempt.ContactID, attempt.Method); err != nil {
+				log.Printf("Error updating phone verification time: %v", err)
+			}
+		}
+	}
+
+	if !valid {
+		if attempt.Attempts >= attempt.MaxAttempts {
+			verificationAttempts.remove(req.VerificationToken)
+		}
+		s.SaveLogEvent(attempt.InstanceID, attempt.UserID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_SECOND_FACTOR_FAILED, "")
+		return nil, status.Error(codes.Unauthenticated, "invalid verification code")
+	}
+	verificationAttempts.remove(req.VerificationToken)
+
+	user, err := s.userDBservice.GetUser(attempt.InstanceID, attempt.UserID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response, err := s.issueLoginTokens(attempt.InstanceID, user)
+	if err != nil {
+		log.Printf("Error generating tokens after second factor: %v", err)
+		return nil, status.Error(codes.Internal, "failed to generate tokens")
+	}
+	if err := s.userDBservice.UpdateLoginTime(attempt.InstanceID, attempt.UserID); err != nil {
+		log.Printf("Error updating login time: %v", err)
+	}
+
+	s.SaveLogEvent(attempt.InstanceID, attempt.UserID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_SECOND_FACTOR_LOGIN_COMPLETE, "")
+	return response, nil
+}
+
+// getUserWithPassword loads the user and checks password against their account.
+func (s *userManagementServer) getUserWithPassword(instanceID, userID, password string) (models.User, error) {
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return user, status.Error(codes.Internal, err.Error())
+	}
+	return user, checkReauthPassword(instanceID, user, password, "")
+}
+
+// checkReauthPassword checks the password a logged in user entered to confirm a sensitive
+// change. Attempts are rate limited per user and client IP.
+func checkReauthPassword(instanceID string, user models.User, password, clientIP string) error {
+	if err := checkReauthPasswordRateLimits(instanceID, user.ID.Hex(), clientIP); err != nil {
+		return err
+	}
+	match, err := pwhash.ComparePasswordWithHash(user.Account.Password, password)
+	if err != nil || !match {
+		return status.Error(codes.PermissionDenied, "wrong password")
+	}
+	return nil
+}
+
+// secondFactorChannel returns the channel codes are sent to contact over: preferred if allowed,
+// otherwise the first allowed one of codeChannels, "" if none.
+func secondFactorChannel(contact models.ContactInfo, preferred string) string {
+	if containsString(codeChannels, preferred) && phoneChannelAllowed(contact, preferred) {
+		return preferred
+	}
+	for _, channel := range codeChannels {
+		if phoneChannelAllowed(contact, channel) {
+			return channel
+		}
+	}
+	return ""
+}
+
+// generateRecoveryCodes returns new recovery codes, formatted for the user, and their hashes.
+func generateRecoveryCodes() ([]string, []models.RecoveryCode, error) {
+	plainCodes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashedCodes := make([]models.RecoveryCode, 0, RECOVERY_CODE_COUNT)
+	alphabetSize := big.NewInt(int64(len(RECOVERY_CODE_ALPHABET)))
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		var b strings.Builder
+		for j := 0; j < RECOVERY_CODE_LENGTH; j++ {
+			if j == RECOVERY_CODE_LENGTH/2 {
+				b.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			b.WriteByte(RECOVERY_CODE_ALPHABET[n.Int64()])
+		}
+		plainCodes = append(plainCodes, b.String())
+		hashedCodes = append(hashedCodes, models.RecoveryCode{Hash: hashRecoveryCode(b.String())})
+	}
+	return plainCodes, hashedCodes, nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed by the user, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
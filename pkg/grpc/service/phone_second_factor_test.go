@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func addSecondFactorTestUser(t *testing.T, accountID string, phoneNumber string) models.User {
+	now := time.Now().Unix()
+	contactID := primitive.NewObjectID()
+	userID, err := testUserDBService.AddUser(testInstanceID, models.User{
+		Account: models.Account{
+			AccountID:          accountID,
+			AccountConfirmedAt: now,
+		},
+		ContactInfos: []models.ContactInfo{
+			{ID: contactID, Type: "phone", Phone: phoneNumber, ConfirmedAt: now},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The second factor phone is not one of the user's contacts anymore: the challenge accepts
+	// recovery codes only and no code has to be sent
+	err = testUserDBService.SetPhoneSecondFactor(testInstanceID, userID, models.PhoneSecondFactor{
+		Enabled:   true,
+		ContactID: primitive.NewObjectID(),
+		Method:    PHONE_CHANNEL_SMS,
+		EnabledAt: now,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user, err := testUserDBService.GetUser(testInstanceID, userID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return user
+}
+
+func TestCompletePasswordLoginWithPhoneSecondFactor(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+	user := addSecondFactorTestUser(t, "phone-second-factor-login@test.com", "+41791230001")
+
+	t.Run("password alone does not issue tokens", func(t *testing.T) {
+		token, challenge, err := s.completePasswordLogin(testInstanceID, user, "127.0.0.1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token != nil {
+			t.Errorf("expected no tokens, got %v", token)
+		}
+		if challenge == nil || challenge.VerificationToken == "" {
+			t.Fatalf("expected a second factor challenge, got %v", challenge)
+		}
+		attempt, exists := verificationAttempts.get(challenge.VerificationToken)
+		if !exists || attempt.Purpose != VERIFICATION_PURPOSE_SECOND_FACTOR || attempt.UserID != user.ID.Hex() {
+			t.Errorf("expected a pending second factor attempt, got %v", attempt)
+		}
+	})
+
+	t.Run("challenge cannot be completed as phone login", func(t *testing.T) {
+		_, challenge, err := s.completePasswordLogin(testInstanceID, user, "127.0.0.1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		previousConfig := phoneVerificationConfig
+		defer func() { phoneVerificationConfig = previousConfig }()
+		phoneLogin := true
+		phoneVerificationConfig.PhoneLogin = &phoneLogin
+
+		_, err = s.LoginWithPhoneCode(context.Background(), &api.PhoneLoginRequest{
+			InstanceId:        testInstanceID,
+			VerificationToken: challenge.VerificationToken,
+			Code:              "000000",
+		})
+		if err == nil {
+			t.Error("expected login to be refused")
+		}
+	})
+}
+
+func TestFindPhoneLoginUserWithPhoneSecondFactor(t *testing.T) {
+	s := userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+	addSecondFactorTestUser(t, "phone-second-factor-phone-login@test.com", "+41791230002")
+
+	if userID := s.findPhoneLoginUser(testInstanceID, "+41791230002"); userID != "" {
+		t.Errorf("expected phone login to be refused, got user %s", userID)
+	}
+}
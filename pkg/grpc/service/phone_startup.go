@@ -0,0 +1,30 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/influenzanet/user-management-service/pkg/dbs/userdb"
+)
+
+// StartPhoneServices prepares the phone features before the server accepts requests. main
+// calls it once, after connecting to the databases, with the instances the service runs for,
+// and exits if it fails. It sets up the secrets provider, creates the phone number lookup index
+// of each instance, used by phone login and the phoneUniqueness check, sets the JWT signing key
+// and checks the WhatsApp templates.
+func StartPhoneServices(userDBService *userdb.UserDBService, instanceIDs []string) error {
+	if err := setupSecretsProvider(); err != nil {
+		return fmt.Errorf("invalid secrets provider configuration: %w", err)
+	}
+	for _, instanceID := range instanceIDs {
+		if err := userDBService.CreateIndexForPhoneNumbers(instanceID); err != nil {
+			return fmt.Errorf("creating phone number index for %s: %w", instanceID, err)
+		}
+	}
+	if err := setupJWTKey(); err != nil {
+		return fmt.Errorf("setting up the JWT key: %w", err)
+	}
+	if err := startWhatsAppTemplateChecks(); err != nil {
+		return fmt.Errorf("checking WhatsApp templates: %w", err)
+	}
+	return nil
+}
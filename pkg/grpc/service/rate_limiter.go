@@ -0,0 +1,237 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/phone"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Scopes phone verification sends are limited by
+const (
+	RATE_LIMIT_SCOPE_USER    = "user"
+	RATE_LIMIT_SCOPE_PHONE   = "phone"
+	RATE_LIMIT_SCOPE_IP      = "ip"
+	RATE_LIMIT_SCOPE_COUNTRY = "country"
+)
+
+// Environment variables configuring the limits, as "<count>/<window>", e.g. "5/1h".
+// A count of 0 disables the limit for that scope.
+const (
+	ENV_PHONE_VERIFICATION_RATE_LIMIT_USER    = "PHONE_VERIFICATION_RATE_LIMIT_USER"
+	ENV_PHONE_VERIFICATION_RATE_LIMIT_PHONE   = "PHONE_VERIFICATION_RATE_LIMIT_PHONE"
+	ENV_PHONE_VERIFICATION_RATE_LIMIT_IP      = "PHONE_VERIFICATION_RATE_LIMIT_IP"
+	ENV_PHONE_VERIFICATION_RATE_LIMIT_COUNTRY = "PHONE_VERIFICATION_RATE_LIMIT_COUNTRY"
+)
+
+// Environment variables limiting password re-authentication attempts, in the same format
+const (
+	ENV_REAUTH_PASSWORD_RATE_LIMIT_USER = "REAUTH_PASSWORD_RATE_LIMIT_USER"
+	ENV_REAUTH_PASSWORD_RATE_LIMIT_IP   = "REAUTH_PASSWORD_RATE_LIMIT_IP"
+)
+
+type rateLimitRule struct {
+	Scope  string
+	Limit  int
+	Window time.Duration
+}
+
+// How often Allow drops the keys of all scopes whose window has emptied, so keys that are never
+// used again do not stay in memory
+const rateLimitSweepInterval = 10 * time.Minute
+
+// verificationRateLimiter counts verification sends in a sliding window for each scope.
+type verificationRateLimiter struct {
+	mu        sync.Mutex
+	rules     []rateLimitRule
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+var phoneVerificationRateLimiter = newVerificationRateLimiter([]rateLimitRule{
+	rateLimitRuleFromEnv(RATE_LIMIT_SCOPE_USER, ENV_PHONE_VERIFICATION_RATE_LIMIT_USER, 5, time.Hour),
+	rateLimitRuleFromEnv(RATE_LIMIT_SCOPE_PHONE, ENV_PHONE_VERIFICATION_RATE_LIMIT_PHONE, 3, time.Hour),
+	rateLimitRuleFromEnv(RATE_LIMIT_SCOPE_IP, ENV_PHONE_VERIFICATION_RATE_LIMIT_IP, 10, time.Hour),
+	rateLimitRuleFromEnv(RATE_LIMIT_SCOPE_COUNTRY, ENV_PHONE_VERIFICATION_RATE_LIMIT_COUNTRY, 200, time.Hour),
+})
+
+// Password checks of already logged in users, e.g. before removing a phone number, so a stolen
+// access token cannot be used to guess the password
+var reauthPasswordRateLimiter = newVerificationRateLimiter([]rateLimitRule{
+	rateLimitRuleFromEnv(RATE_LIMIT_SCOPE_USER, ENV_REAUTH_PASSWORD_RATE_LIMIT_USER, 5, 15*time.Minute),
+	rateLimitRuleFromEnv(RATE_LIMIT_SCOPE_IP, ENV_REAUTH_PASSWORD_RATE_LIMIT_IP, 20, 15*time.Minute),
+})
+
+func newVerificationRateLimiter(rules []rateLimitRule) *verificationRateLimiter {
+	return &verificationRateLimiter{
+		rules:  rules,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// rateLimitRuleFromEnv reads a "<count>/<window>" limit from envName, falling back to the defaults
+// if the variable is missing or malformed.
+func rateLimitRuleFromEnv(scope, envName string, defaultLimit int, defaultWindow time.Duration) rateLimitRule {
+	rule := rateLimitRule{Scope: scope, Limit: defaultLimit, Window: defaultWindow}
+	value := os.Getenv(envName)
+	if value == "" {
+		return rule
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit < 0 {
+		log.Printf("Invalid %s value %q, using default", envName, value)
+		return rule
+	}
+	window := defaultWindow
+	if len(parts) == 2 {
+		window, err = time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || window <= 0 {
+			log.Printf("Invalid %s value %q, using default", envName, value)
+			return rule
+		}
+	}
+	rule.Limit = limit
+	rule.Window = window
+	return rule
+}
+
+// Allow checks every scope in keys (scope -> key) against its rule and, if none is exceeded,
+// records the send for all of them. If a limit is reached, the first exceeded scope and the
+// time until a slot frees up are returned.
+func (l *verificationRateLimiter) Allow(keys map[string]string, now time.Time) (bool, string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	for _, rule := range l.rules {
+		key, ok := keys[rule.Scope]
+		if !ok || key == "" || rule.Limit <= 0 {
+			continue
+		}
+		eventKey := rule.Scope + ":" + key
+		events := l.pruneEvents(eventKey, now.Add(-rule.Window))
+		if len(events) >= rule.Limit {
+			retryAfter := events[len(events)-rule.Limit].Add(rule.Window).Sub(now)
+			return false, rule.Scope, retryAfter
+		}
+	}
+
+	for _, rule := range l.rules {
+		key, ok := keys[rule.Scope]
+		if !ok || key == "" || rule.Limit <= 0 {
+			continue
+		}
+		eventKey := rule.Scope + ":" + key
+		l.events[eventKey] = append(l.events[eventKey], now)
+	}
+	return true, "", 0
+}
+
+// pruneEvents drops events older than since and returns the remaining ones.
+func (l *verificationRateLimiter) pruneEvents(eventKey string, since time.Time) []time.Time {
+	events := l.events[eventKey]
+	i := 0
+	for i < len(events) && !events[i].After(since) {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(l.events, eventKey)
+		return nil
+	}
+	l.events[eventKey] = events
+	return events
+}
+
+// sweep prunes the events of every key, deleting keys left without events in their window.
+func (l *verificationRateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for _, rule := range l.rules {
+		prefix := rule.Scope + ":"
+		for eventKey := range l.events {
+			if strings.HasPrefix(eventKey, prefix) {
+				l.pruneEvents(eventKey, now.Add(-rule.Window))
+			}
+		}
+	}
+}
+
+// checkVerificationRateLimits records a verification send for the user (if any), destination phone
+// (if any), client IP and country prefix, or returns a ResourceExhausted error carrying a RetryInfo
+// detail if one of the limits has been reached.
+func (s *userManagementServer) checkVerificationRateLimits(instanceID, userID, phoneNumber, clientIP string) error {
+	// Login codes may be requested for unknown numbers, which have no user to count for
+	userKey := ""
+	if userID != "" {
+		userKey = instanceID + "/" + userID
+	}
+	keys := map[string]string{
+		RATE_LIMIT_SCOPE_USER: userKey,
+		RATE_LIMIT_SCOPE_IP:   clientIP,
+	}
+	// Codes sent by email have no phone number to count for
+	if phoneNumber != "" {
+		keys[RATE_LIMIT_SCOPE_PHONE] = instanceID + "/" + phoneNumber
+		keys[RATE_LIMIT_SCOPE_COUNTRY] = instanceID + "/" + phoneCountryPrefix(phoneNumber)
+	}
+
+	allowed, scope, retryAfter := phoneVerificationRateLimiter.Allow(keys, time.Now())
+	if allowed {
+		return nil
+	}
+
+	log.Printf("Phone verification rate limit reached for %s (user %s, instance %s)", scope, userID, instanceID)
+	return resourceExhaustedError(fmt.Sprintf("too many verification requests (%s limit)", scope), retryAfter)
+}
+
+// checkReauthPasswordRateLimits records a password re-authentication attempt for the user and
+// client IP, or returns a ResourceExhausted error if one of the limits has been reached.
+func checkReauthPasswordRateLimits(instanceID, userID, clientIP string) error {
+	keys := map[string]string{
+		RATE_LIMIT_SCOPE_USER: instanceID + "/" + userID,
+		RATE_LIMIT_SCOPE_IP:   clientIP,
+	}
+
+	allowed, scope, retryAfter := reauthPasswordRateLimiter.Allow(keys, time.Now())
+	if allowed {
+		return nil
+	}
+
+	log.Printf("Password re-authentication rate limit reached for %s (user %s, instance %s)", scope, userID, instanceID)
+	return resourceExhaustedError(fmt.Sprintf("too many password attempts (%s limit)", scope), retryAfter)
+}
+
+// resourceExhaustedError builds a ResourceExhausted status with a RetryInfo detail the gateway
+// turns into a Retry-After header.
+func resourceExhaustedError(msg string, retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, msg)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// phoneCountryPrefix returns the country calling code of an E.164 number, used to group sends
+// per destination country.
+func phoneCountryPrefix(phoneNumber string) string {
+	n, err := phone.Parse(phoneNumber, "")
+	if err != nil {
+		return strings.TrimPrefix(phoneNumber, "+")
+	}
+	return n.CountryCode
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerificationRateLimiterAllow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rules := []rateLimitRule{
+		{Scope: RATE_LIMIT_SCOPE_USER, Limit: 2, Window: time.Hour},
+		{Scope: RATE_LIMIT_SCOPE_IP, Limit: 3, Window: 10 * time.Minute},
+		{Scope: RATE_LIMIT_SCOPE_PHONE, Limit: 0, Window: time.Hour},
+	}
+	type send struct {
+		keys       map[string]string
+		after      time.Duration
+		allowed    bool
+		scope      string
+		retryAfter time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		sends []send
+	}{
+		{
+			name: "limit per key",
+			sends: []send{
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a"}, 0, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a"}, time.Minute, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a"}, 2 * time.Minute, false, RATE_LIMIT_SCOPE_USER, 58 * time.Minute},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "b"}, 2 * time.Minute, true, "", 0},
+			},
+		},
+		{
+			name: "slot frees up after the window",
+			sends: []send{
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a"}, 0, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a"}, 30 * time.Minute, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a"}, time.Hour, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a"}, time.Hour + time.Minute, false, RATE_LIMIT_SCOPE_USER, 29 * time.Minute},
+			},
+		},
+		{
+			name: "refused sends are not counted",
+			sends: []send{
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a", RATE_LIMIT_SCOPE_IP: "ip"}, 0, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a", RATE_LIMIT_SCOPE_IP: "ip"}, 0, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "a", RATE_LIMIT_SCOPE_IP: "ip"}, 0, false, RATE_LIMIT_SCOPE_USER, time.Hour},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "b", RATE_LIMIT_SCOPE_IP: "ip"}, 0, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "c", RATE_LIMIT_SCOPE_IP: "ip"}, 0, false, RATE_LIMIT_SCOPE_IP, 10 * time.Minute},
+			},
+		},
+		{
+			name: "empty keys and disabled scopes are not limited",
+			sends: []send{
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "", RATE_LIMIT_SCOPE_PHONE: "+41791234567"}, 0, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "", RATE_LIMIT_SCOPE_PHONE: "+41791234567"}, 0, true, "", 0},
+				{map[string]string{RATE_LIMIT_SCOPE_USER: "", RATE_LIMIT_SCOPE_PHONE: "+41791234567"}, 0, true, "", 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newVerificationRateLimiter(rules)
+			for i, s := range tt.sends {
+				allowed, scope, retryAfter := limiter.Allow(s.keys, start.Add(s.after))
+				if allowed != s.allowed || scope != s.scope || retryAfter != s.retryAfter {
+					t.Errorf("send %d: expected %v %q %v, got %v %q %v", i, s.allowed, s.scope, s.retryAfter, allowed, scope, retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitRuleFromEnv(t *testing.T) {
+	const envName = "TEST_RATE_LIMIT"
+	tests := []struct {
+		value  string
+		limit  int
+		window time.Duration
+	}{
+		{"", 5, time.Hour},
+		{"10/30m", 10, 30 * time.Minute},
+		{" 3 / 2h ", 3, 2 * time.Hour},
+		{"7", 7, time.Hour},
+		{"0/1h", 0, time.Hour},
+		{"-1/1h", 5, time.Hour},
+		{"many/1h", 5, time.Hour},
+		{"4/soon", 5, time.Hour},
+		{"4/-1h", 5, time.Hour},
+		{"4/0s", 5, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(envName, tt.value)
+			rule := rateLimitRuleFromEnv(RATE_LIMIT_SCOPE_USER, envName, 5, time.Hour)
+			if rule.Scope != RATE_LIMIT_SCOPE_USER || rule.Limit != tt.limit || rule.Window != tt.window {
+				t.Errorf("expected %d/%v, got %d/%v", tt.limit, tt.window, rule.Limit, rule.Window)
+			}
+		})
+	}
+}
+
+func TestVerificationRateLimiterSweep(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newVerificationRateLimiter([]rateLimitRule{
+		{Scope: RATE_LIMIT_SCOPE_USER, Limit: 5, Window: time.Hour},
+		{Scope: RATE_LIMIT_SCOPE_IP, Limit: 5, Window: 5 * time.Minute},
+	})
+	limiter.Allow(map[string]string{RATE_LIMIT_SCOPE_USER: "a", RATE_LIMIT_SCOPE_IP: "ip1"}, start)
+	limiter.Allow(map[string]string{RATE_LIMIT_SCOPE_USER: "b", RATE_LIMIT_SCOPE_IP: "ip2"}, start.Add(time.Minute))
+
+	// "a" and both IPs are outside their windows, "b" is not
+	limiter.Allow(map[string]string{RATE_LIMIT_SCOPE_USER: "c"}, start.Add(time.Hour+30*time.Second))
+
+	keys := []string{}
+	for k := range limiter.events {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	expected := []string{RATE_LIMIT_SCOPE_USER + ":b", RATE_LIMIT_SCOPE_USER + ":c"}
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected keys %v, got %v", expected, keys)
+	}
+}
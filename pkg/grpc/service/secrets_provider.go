@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/secrets"
+	"github.com/influenzanet/user-management-service/pkg/tokens"
+)
+
+const (
+	ENV_WHATSAPP_API_TOKEN = "WHATSAPP_API_TOKEN"
+	ENV_JWT_TOKEN_KEY      = "JWT_TOKEN_KEY"
+	// How often secrets that can be rotated are reloaded, as a duration. Default 5m.
+	ENV_SECRETS_REFRESH_INTERVAL = "SECRETS_REFRESH_INTERVAL"
+)
+
+// Where credentials are read from: the environment until StartPhoneServices sets up the
+// provider configured with SECRETS_PROVIDER, see secrets.FromEnv
+var secretsProvider secrets.Provider = secrets.Env{}
+
+var secretsRefreshInterval = durationFromEnv(ENV_SECRETS_REFRESH_INTERVAL, 5*time.Minute)
+
+var (
+	rotatingSecretsMu sync.Mutex
+	rotatingSecrets   = map[string]*secrets.Rotating{}
+)
+
+// setupSecretsProvider switches to the secrets provider configured in the environment. It runs
+// before the server accepts requests, so no secret is loaded from the previous provider.
+func setupSecretsProvider() error {
+	provider, err := secrets.FromEnv()
+	if err != nil {
+		return err
+	}
+
+	rotatingSecretsMu.Lock()
+	defer rotatingSecretsMu.Unlock()
+	secretsProvider = provider
+	return nil
+}
+
+// setupJWTKey resolves the JWT signing key through the secrets provider and hands it to the
+// token package, so a key mounted as a file or kept in Vault never goes through the environment.
+func setupJWTKey() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rotatingSecretsMu.Lock()
+	provider := secretsProvider
+	rotatingSecretsMu.Unlock()
+
+	key, err := provider.Get(ctx, ENV_JWT_TOKEN_KEY)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", ENV_JWT_TOKEN_KEY, err)
+	}
+	return tokens.SetSigningKey(key)
+}
+
+// rotatingSecret returns the first of names the secrets provider has, reloaded every
+// SECRETS_REFRESH_INTERVAL so rotated credentials are used without a restart.
+func rotatingSecret(names ...string) *secrets.Rotating {
+	rotatingSecretsMu.Lock()
+	defer rotatingSecretsMu.Unlock()
+
+	key := strings.Join(names, ",")
+	secret, ok := rotatingSecrets[key]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		secret = secrets.NewRotating(ctx, secretsProvider, names...)
+		cancel()
+		rotatingSecrets[key] = secret
+		go secret.Run(context.Background(), secretsRefreshInterval)
+	}
+	return secret
+}
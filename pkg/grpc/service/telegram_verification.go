@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/phone"
+	"github.com/influenzanet/user-management-service/pkg/telegram"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const VERIFICATION_METHOD_TELEGRAM = "telegram"
+
+const (
+	ENV_TELEGRAM_BOT_TOKEN      = "TELEGRAM_BOT_TOKEN"
+	ENV_TELEGRAM_BOT_USERNAME   = "TELEGRAM_BOT_USERNAME"
+	ENV_TELEGRAM_API_URL        = "TELEGRAM_API_URL" // e.g. a fake Bot API server for local runs
+	ENV_TELEGRAM_WEBHOOK_SECRET = "TELEGRAM_WEBHOOK_SECRET"
+)
+
+const TELEGRAM_CONFIRM_CALLBACK_PREFIX = "confirm:"
+
+var telegramClient = telegram.NewClient(os.Getenv(ENV_TELEGRAM_API_URL), os.Getenv(ENV_TELEGRAM_BOT_TOKEN))
+
+// telegramDeepLink returns the link starting the bot with the attempt's link code.
+func telegramDeepLink(linkCode string) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", os.Getenv(ENV_TELEGRAM_BOT_USERNAME), linkCode)
+}
+
+// deepLink returns the link the user has to open to receive the code, for Telegram attempts.
+func (attempt *VerificationAttempt) deepLink() string {
+	if attempt.Method != VERIFICATION_METHOD_TELEGRAM || attempt.TelegramLinkCode == "" {
+		return ""
+	}
+	return telegramDeepLink(attempt.TelegramLinkCode)
+}
+
+func generateTelegramLinkCode() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// sendTelegramVerification sends the code of the attempt to its linked chat, with a button
+// confirming the number directly. Before the user has opened the deep link and shared their
+// number there is no chat to send to yet: the code is sent once that happens.
+func (s *userManagementServer) sendTelegramVerification(attempt *VerificationAttempt) error {
+	if attempt.TelegramLinkCode == "" {
+		linkCode, err := generateTelegramLinkCode()
+		if err != nil {
+			return err
+		}
+		attempt.TelegramLinkCode = linkCode
+	}
+	if attempt.TelegramChatID == 0 || !attempt.TelegramPhoneConfirmed {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return telegramClient.SendMessage(ctx, telegram.SendMessageRequest{
+		ChatID: attempt.TelegramChatID,
+		Text:   fmt.Sprintf("Your InfluenzaNet verification code is: %s. This code will expire in %d minutes.", attempt.Code, VERIFICATION_CODE_EXPIRY_MINUTES),
+		ReplyMarkup: telegram.InlineKeyboardMarkup{
+			InlineKeyboard: [][]telegram.InlineKeyboardButton{{
+				{Text: "Confirm my phone number", CallbackData: TELEGRAM_CONFIRM_CALLBACK_PREFIX + attempt.TelegramLinkCode},
+			}},
+		},
+	})
+}
+
+// HandleTelegramUpdate processes an update the bot webhook received. Errors in the update
+// itself are answered to the chat, not returned, as Telegram would deliver it again.
+func (s *userManagementServer) HandleTelegramUpdate(ctx context.Context, req *api.TelegramUpdateRequest) (*api.ServiceStatus, error) {
+	if req == nil || len(req.Update) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	secret := os.Getenv(ENV_TELEGRAM_WEBHOOK_SECRET)
+	if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(req.SecretToken)) != 1 {
+		return nil, status.Error(codes.PermissionDenied, "invalid webhook secret")
+	}
+
+	var update telegram.Update
+	if err := json.Unmarshal(req.Update, &update); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid update")
+	}
+
+	switch {
+	case update.CallbackQuery != nil:
+		s.handleTelegramConfirm(update.CallbackQuery)
+	case update.Message != nil && update.Message.Contact != nil:
+		s.handleTelegramContact(update.Message)
+	case update.Message != nil && strings.HasPrefix(update.Message.Text, "/start"):
+		s.handleTelegramStart(update.Message)
+	}
+
+	return &api.ServiceStatus{
+		Status: api.ServiceStatus_NORMAL,
+		Msg:    "update processed",
+	}, nil
+}
+
+// handleTelegramStart links the chat to the attempt of the deep link and asks for the user's
+// phone number, which Telegram only shares for the user's own account.
+func (s *userManagementServer) handleTelegramStart(message *telegram.Message) {
+	linkCode := strings.TrimSpace(strings.TrimPrefix(message.Text, "/start"))
+	attempt := findTelegramAttempt(linkCode)
+	if attempt == nil {
+		s.replyTelegram(message.Chat.ID, "This verification link is invalid or has expired. Please start again from the website.", nil)
+		return
+	}
+
+	verificationAttempts.update(attempt.Token, func(attempt *VerificationAttempt) {
+		attempt.TelegramChatID = message.Chat.ID
+		attempt.TelegramPhoneConfirmed = false
+	})
+	s.replyTelegram(message.Chat.ID, "Please share your phone number to verify it.", telegram.ReplyKeyboardMarkup{
+		Keyboard:        [][]telegram.KeyboardButton{{{Text: "Share my phone number", RequestContact: true}}},
+		OneTimeKeyboard: true,
+		ResizeKeyboard:  true,
+	})
+}
+
+// handleTelegramContact checks the shared number against the one being verified and, if it
+// matches, sends the code.
+func (s *userManagementServer) handleTelegramContact(message *telegram.Message) {
+	attempt := findTelegramAttemptByChat(message.Chat.ID)
+	if attempt == nil {
+		s.replyTelegram(message.Chat.ID, "No verification is pending. Please start again from the website.", telegram.ReplyKeyboardRemove{RemoveKeyboard: true})
+		return
+	}
+
+	contact := message.Contact
+	if message.From == nil || contact.UserID != message.From.ID {
+		s.replyTelegram(message.Chat.ID, "Please share your own phone number.", nil)
+		return
+	}
+	sharedNumber, err := phone.Normalize(contact.PhoneNumber, "")
+	if err != nil && !strings.HasPrefix(contact.PhoneNumber, "+") {
+		// Telegram may omit the leading "+"
+		sharedNumber, err = phone.Normalize("+"+contact.PhoneNumber, "")
+	}
+	if err != nil || sharedNumber != attempt.PhoneNumber {
+		s.replyTelegram(message.Chat.ID, "This Telegram account does not use the phone number being verified.", telegram.ReplyKeyboardRemove{RemoveKeyboard: true})
+		return
+	}
+
+	attempt, exists := verificationAttempts.update(attempt.Token, func(attempt *VerificationAttempt) {
+		attempt.TelegramPhoneConfirmed = true
+	})
+	if !exists {
+		return
+	}
+	s.replyTelegram(message.Chat.ID, "Thank you, your phone number matches.", telegram.ReplyKeyboardRemove{RemoveKeyboard: true})
+	if err := s.sendTelegramVerification(attempt); err != nil {
+		log.Printf("Error sending Telegram verification: %v", err)
+	}
+}
+
+// handleTelegramConfirm completes the verification when the confirm button is pressed in the
+// linked chat.
+func (s *userManagementServer) handleTelegramConfirm(query *telegram.CallbackQuery) {
+	answer := "This verification has expired."
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := telegramClient.AnswerCallbackQuery(ctx, telegram.AnswerCallbackQueryRequest{CallbackQueryID: query.ID, Text: answer}); err != nil {
+			log.Printf("Error answering Telegram callback: %v", err)
+		}
+	}()
+
+	if !strings.HasPrefix(query.Data, TELEGRAM_CONFIRM_CALLBACK_PREFIX) || query.Message == nil {
+		return
+	}
+	attempt := findTelegramAttempt(strings.TrimPrefix(query.Data, TELEGRAM_CONFIRM_CALLBACK_PREFIX))
+	if attempt == nil || attempt.TelegramChatID != query.Message.Chat.ID || !attempt.TelegramPhoneConfirmed {
+		return
+	}
+
+	message, err := s.completePhoneVerification(attempt)
+	if err != nil {
+		log.Printf("Error completing Telegram verification: %v", err)
+		answer = "Your phone number could not be verified."
+		return
+	}
+	answer = message
+}
+
+func (s *userManagementServer) replyTelegram(chatID int64, text string, replyMarkup interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err := telegramClient.SendMessage(ctx, telegram.SendMessageRequest{
+		ChatID:      chatID,
+		Text:        text,
+		ReplyMarkup: replyMarkup,
+	})
+	if err != nil {
+		log.Printf("Error sending Telegram message: %v", err)
+	}
+}
+
+// findTelegramAttempt returns the pending Telegram attempt with linkCode, if not expired.
+func findTelegramAttempt(linkCode string) *VerificationAttempt {
+	if linkCode == "" {
+		return nil
+	}
+	return verificationAttempts.findOne(func(attempt *VerificationAttempt) bool {
+		return attempt.Method == VERIFICATION_METHOD_TELEGRAM && attempt.Status == "pending" &&
+			subtle.ConstantTimeCompare([]byte(attempt.TelegramLinkCode), []byte(linkCode)) == 1 &&
+			time.Now().Before(attempt.ExpiresAt)
+	})
+}
+
+// findTelegramAttemptByChat returns the pending, not expired Telegram attempt linked to chatID.
+func findTelegramAttemptByChat(chatID int64) *VerificationAttempt {
+	return verificationAttempts.findOne(func(attempt *VerificationAttempt) bool {
+		return attempt.Method == VERIFICATION_METHOD_TELEGRAM && attempt.Status == "pending" &&
+			attempt.TelegramChatID == chatID && time.Now().Before(attempt.ExpiresAt)
+	})
+}
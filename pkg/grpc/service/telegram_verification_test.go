@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/telegram"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testTelegramWebhookSecret = "test-webhook-secret"
+
+func sendTestTelegramUpdate(t *testing.T, s *userManagementServer, update telegram.Update) {
+	payload, err := json.Marshal(update)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = s.HandleTelegramUpdate(context.Background(), &api.TelegramUpdateRequest{
+		SecretToken: testTelegramWebhookSecret,
+		Update:      payload,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func startTestTelegramVerification(t *testing.T, s *userManagementServer, accountID string, phoneNumber string) (string, *VerificationAttempt) {
+	contactID := primitive.NewObjectID()
+	userID, err := testUserDBService.AddUser(testInstanceID, models.User{
+		Account: models.Account{AccountID: accountID},
+		ContactInfos: []models.ContactInfo{
+			{ID: contactID, Type: "phone", Phone: phoneNumber},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attempt, err := s.startPhoneVerification(&VerificationAttempt{
+		UserID:      userID,
+		InstanceID:  testInstanceID,
+		Purpose:     VERIFICATION_PURPOSE_VERIFY_PHONE,
+		PhoneNumber: phoneNumber,
+		ContactID:   contactID.Hex(),
+		Method:      VERIFICATION_METHOD_TELEGRAM,
+	}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(attempt.deepLink(), "?start="+attempt.TelegramLinkCode) || attempt.TelegramLinkCode == "" {
+		t.Fatalf("unexpected deep link: %s", attempt.deepLink())
+	}
+	return userID, attempt
+}
+
+func TestTelegramVerificationFlow(t *testing.T) {
+	fakeServer := telegram.NewFakeServer()
+	botAPI := httptest.NewServer(fakeServer)
+	defer botAPI.Close()
+
+	previousClient := telegramClient
+	defer func() { telegramClient = previousClient }()
+	telegramClient = telegram.NewClient(botAPI.URL, "test-bot-token")
+	t.Setenv(ENV_TELEGRAM_WEBHOOK_SECRET, testTelegramWebhookSecret)
+
+	s := &userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	t.Run("start, contact and confirm verify the number", func(t *testing.T) {
+		const chatID, telegramUserID = 1001, 2001
+		userID, attempt := startTestTelegramVerification(t, s, "telegram-flow@test.com", "+41791230101")
+
+		sendTestTelegramUpdate(t, s, telegram.Update{Message: &telegram.Message{
+			From: &telegram.User{ID: telegramUserID},
+			Chat: telegram.Chat{ID: chatID},
+			Text: "/start " + attempt.TelegramLinkCode,
+		}})
+		// Telegram omits the leading "+" of shared numbers
+		sendTestTelegramUpdate(t, s, telegram.Update{Message: &telegram.Message{
+			From:    &telegram.User{ID: telegramUserID},
+			Chat:    telegram.Chat{ID: chatID},
+			Contact: &telegram.Contact{PhoneNumber: "41791230101", UserID: telegramUserID},
+		}})
+
+		messages := fakeServer.Messages(chatID)
+		if len(messages) != 3 || !strings.Contains(messages[2], attempt.Code) {
+			t.Fatalf("expected the code to be sent after the contact, got %v", messages)
+		}
+
+		sendTestTelegramUpdate(t, s, telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+			ID:      "callback-1",
+			From:    telegram.User{ID: telegramUserID},
+			Message: &telegram.Message{Chat: telegram.Chat{ID: chatID}},
+			Data:    TELEGRAM_CONFIRM_CALLBACK_PREFIX + attempt.TelegramLinkCode,
+		}})
+
+		user, err := testUserDBService.GetUser(testInstanceID, userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		contact := findPhoneContact(user, attempt.ContactID)
+		if contact == nil || contact.ConfirmedAt <= 0 || contact.VerifiedVia != VERIFICATION_METHOD_TELEGRAM {
+			t.Errorf("expected the number to be verified over telegram, got %v", contact)
+		}
+	})
+
+	t.Run("contact with another number does not get the code", func(t *testing.T) {
+		const chatID, telegramUserID = 1002, 2002
+		userID, attempt := startTestTelegramVerification(t, s, "telegram-other-number@test.com", "+41791230102")
+
+		sendTestTelegramUpdate(t, s, telegram.Update{Message: &telegram.Message{
+			From: &telegram.User{ID: telegramUserID},
+			Chat: telegram.Chat{ID: chatID},
+			Text: "/start " + attempt.TelegramLinkCode,
+		}})
+		sendTestTelegramUpdate(t, s, telegram.Update{Message: &telegram.Message{
+			From:    &telegram.User{ID: telegramUserID},
+			Chat:    telegram.Chat{ID: chatID},
+			Contact: &telegram.Contact{PhoneNumber: "+41791239999", UserID: telegramUserID},
+		}})
+		sendTestTelegramUpdate(t, s, telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+			ID:      "callback-2",
+			From:    telegram.User{ID: telegramUserID},
+			Message: &telegram.Message{Chat: telegram.Chat{ID: chatID}},
+			Data:    TELEGRAM_CONFIRM_CALLBACK_PREFIX + attempt.TelegramLinkCode,
+		}})
+
+		for _, message := range fakeServer.Messages(chatID) {
+			if strings.Contains(message, attempt.Code) {
+				t.Errorf("code sent for a different number: %s", message)
+			}
+		}
+		user, err := testUserDBService.GetUser(testInstanceID, userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if contact := findPhoneContact(user, attempt.ContactID); contact == nil || contact.ConfirmedAt > 0 {
+			t.Errorf("expected the number to stay unverified, got %v", contact)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// verificationAttemptStore keeps the pending verification attempts in memory (in production, use
+// Redis or database). Attempts are read and changed by concurrent requests, webhooks and
+// background sends, so they are only handed out as copies and changed through the store.
+type verificationAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*VerificationAttempt
+}
+
+var verificationAttempts = newVerificationAttemptStore()
+
+func newVerificationAttemptStore() *verificationAttemptStore {
+	return &verificationAttemptStore{
+		attempts: make(map[string]*VerificationAttempt),
+	}
+}
+
+// get returns a copy of the attempt for token.
+func (store *verificationAttemptStore) get(token string) (*VerificationAttempt, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempt, exists := store.attempts[token]
+	if !exists {
+		return nil, false
+	}
+	stored := *attempt
+	return &stored, true
+}
+
+// put stores a copy of attempt under its token, replacing any attempt stored there.
+func (store *verificationAttemptStore) put(attempt *VerificationAttempt) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored := *attempt
+	store.attempts[attempt.Token] = &stored
+}
+
+// update applies change to the attempt for token and returns a copy of the result. Nothing is
+// changed if the attempt does not exist anymore.
+func (store *verificationAttemptStore) update(token string, change func(attempt *VerificationAttempt)) (*VerificationAttempt, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempt, exists := store.attempts[token]
+	if !exists {
+		return nil, false
+	}
+	change(attempt)
+	stored := *attempt
+	return &stored, true
+}
+
+// recordSend copies what sending the code of sent may have changed to the stored attempt: the
+// channel that worked, the Telegram link and the email fallback link.
+func (store *verificationAttemptStore) recordSend(sent *VerificationAttempt) {
+	store.update(sent.Token, func(attempt *VerificationAttempt) {
+		attempt.Method = sent.Method
+		attempt.TelegramLinkCode = sent.TelegramLinkCode
+		attempt.FallbackToken = sent.FallbackToken
+		attempt.FallbackEmail = sent.FallbackEmail
+		attempt.FallbackLanguage = sent.FallbackLanguage
+		if sent.ExpiresAt.After(attempt.ExpiresAt) {
+			attempt.ExpiresAt = sent.ExpiresAt
+		}
+	})
+}
+
+// countGuess counts a code guess against the attempt for token and returns a copy of it with its
+// status. Expired attempts and attempts without guesses left are removed instead, with status
+// "expired" or "failed"; a missing attempt is reported as nil.
+func (store *verificationAttemptStore) countGuess(token string, now time.Time) (*VerificationAttempt, string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempt, exists := store.attempts[token]
+	if !exists {
+		return nil, ""
+	}
+	switch {
+	case now.After(attempt.ExpiresAt):
+		attempt.Status = "expired"
+		delete(store.attempts, token)
+	case attempt.Attempts >= attempt.MaxAttempts:
+		attempt.Status = "failed"
+		delete(store.attempts, token)
+	default:
+		attempt.Attempts++
+	}
+	stored := *attempt
+	return &stored, stored.Status
+}
+
+// claim marks the pending attempt for token as verified. It reports false if the attempt is gone
+// or was completed already, so a verification is applied at most once.
+func (store *verificationAttemptStore) claim(token string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempt, exists := store.attempts[token]
+	if !exists || attempt.Status != "pending" {
+		return false
+	}
+	attempt.Status = "verified"
+	return true
+}
+
+func (store *verificationAttemptStore) remove(token string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.attempts, token)
+}
+
+// removeAfter removes the attempt for token once delay has passed.
+func (store *verificationAttemptStore) removeAfter(token string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		store.remove(token)
+	})
+}
+
+// find returns copies of the attempts match accepts.
+func (store *verificationAttemptStore) find(match func(attempt *VerificationAttempt) bool) []*VerificationAttempt {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var found []*VerificationAttempt
+	for _, attempt := range store.attempts {
+		if match(attempt) {
+			stored := *attempt
+			found = append(found, &stored)
+		}
+	}
+	return found
+}
+
+// findOne returns a copy of an attempt match accepts, or nil if there is none.
+func (store *verificationAttemptStore) findOne(match func(attempt *VerificationAttempt) bool) *VerificationAttempt {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, attempt := range store.attempts {
+		if match(attempt) {
+			stored := *attempt
+			return &stored
+		}
+	}
+	return nil
+}
+
+// updateWhere applies change to every attempt match accepts.
+func (store *verificationAttemptStore) updateWhere(match func(attempt *VerificationAttempt) bool, change func(attempt *VerificationAttempt)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, attempt := range store.attempts {
+		if match(attempt) {
+			change(attempt)
+		}
+	}
+}
+
+// removeWhere removes every attempt match accepts.
+func (store *verificationAttemptStore) removeWhere(match func(attempt *VerificationAttempt) bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for token, attempt := range store.attempts {
+		if match(attempt) {
+			delete(store.attempts, token)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/phone"
+)
+
+// Environment variables selecting and configuring the voice provider
+const (
+	ENV_VOICE_PROVIDER         = "VOICE_PROVIDER" // "http" or "fake" (default)
+	ENV_VOICE_PROVIDER_URL     = "VOICE_PROVIDER_URL"
+	ENV_VOICE_PROVIDER_API_KEY = "VOICE_PROVIDER_API_KEY"
+	ENV_VOICE_CALLER_ID        = "VOICE_CALLER_ID"
+)
+
+const DEFAULT_VOICE_LANGUAGE = "en"
+
+// Pause between two digits of the code, so it can be written down while listening
+const VOICE_DIGIT_PAUSE = 600 * time.Millisecond
+
+// VoiceCall is a text-to-speech call reading Speech (SSML) to To.
+type VoiceCall struct {
+	To       string `json:"to"`
+	From     string `json:"from"`
+	Language string `json:"language"`
+	Speech   string `json:"ssml"`
+}
+
+// VoiceProvider places text-to-speech calls.
+type VoiceProvider interface {
+	PlaceCall(ctx context.Context, call VoiceCall) error
+}
+
+// HTTPVoiceProvider posts calls as JSON to a provider endpoint (or an adapter in front of one)
+// authenticated with a bearer API key.
+type HTTPVoiceProvider struct {
+	url        string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// FakeVoiceProvider logs calls and keeps them in memory instead of placing them, for local
+// development.
+type FakeVoiceProvider struct {
+	mu    sync.Mutex
+	calls []VoiceCall
+}
+
+// Localized speech, %s being the digits of the code. The code is read twice.
+var voiceSpeechTexts = map[string]string{
+	"en": "Hello, this is InfluenzaNet. Your verification code is: %[1]s. I repeat, your code is: %[1]s. Goodbye.",
+	"it": "Buongiorno, questo è InfluenzaNet. Il tuo codice di verifica è: %[1]s. Ripeto, il tuo codice è: %[1]s. Arrivederci.",
+	"de": "Guten Tag, hier ist InfluenzaNet. Ihr Bestätigungscode lautet: %[1]s. Ich wiederhole, Ihr Code lautet: %[1]s. Auf Wiederhören.",
+	"fr": "Bonjour, ici InfluenzaNet. Votre code de vérification est : %[1]s. Je répète, votre code est : %[1]s. Au revoir.",
+	"nl": "Hallo, dit is InfluenzaNet. Uw verificatiecode is: %[1]s. Ik herhaal, uw code is: %[1]s. Tot ziens.",
+	"es": "Hola, le llamamos de InfluenzaNet. Su código de verificación es: %[1]s. Repito, su código es: %[1]s. Adiós.",
+	"pt": "Olá, fala a InfluenzaNet. O seu código de verificação é: %[1]s. Repito, o seu código é: %[1]s. Adeus.",
+}
+
+var voiceProvider = newVoiceProvider()
+
+func newVoiceProvider() VoiceProvider {
+	switch os.Getenv(ENV_VOICE_PROVIDER) {
+	case "http":
+		return &HTTPVoiceProvider{
+			url:    os.Getenv(ENV_VOICE_PROVIDER_URL),
+			apiKey: os.Getenv(ENV_VOICE_PROVIDER_API_KEY),
+			httpClient: &http.Client{
+				Timeout: 30 * time.Second,
+			},
+		}
+	case "", "fake":
+		return &FakeVoiceProvider{}
+	default:
+		log.Printf("Unknown %s %q, using fake voice provider", ENV_VOICE_PROVIDER, os.Getenv(ENV_VOICE_PROVIDER))
+		return &FakeVoiceProvider{}
+	}
+}
+
+func (p *HTTPVoiceProvider) PlaceCall(ctx context.Context, call VoiceCall) error {
+	if p.url == "" {
+		return fmt.Errorf("voice provider URL not configured")
+	}
+
+	jsonData, err := json.Marshal(call)
+	if err != nil {
+		return fmt.Errorf("failed to marshal call: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("voice provider error: status %d, body: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (p *FakeVoiceProvider) PlaceCall(ctx context.Context, call VoiceCall) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	log.Printf("Fake voice call to %s (%s): %s", call.To, call.Language, call.Speech)
+	p.calls = append(p.calls, call)
+	return nil
+}
+
+// Calls returns the calls placed so far.
+func (p *FakeVoiceProvider) Calls() []VoiceCall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]VoiceCall{}, p.calls...)
+}
+
+// voiceSpeech returns the SSML reading code slowly, digit by digit, in language (falling back to
+// English).
+func voiceSpeech(code, language string) (string, string) {
+	language = strings.ToLower(language)
+	if i := strings.IndexAny(language, "-_"); i > 0 {
+		language = language[:i]
+	}
+	text, ok := voiceSpeechTexts[language]
+	if !ok {
+		language = DEFAULT_VOICE_LANGUAGE
+		text = voiceSpeechTexts[language]
+	}
+
+	digits := make([]string, 0, len(code))
+	for _, digit := range code {
+		digits = append(digits, string(digit))
+	}
+	pause := fmt.Sprintf(`<break time="%dms"/>`, VOICE_DIGIT_PAUSE.Milliseconds())
+	spokenCode := `<prosody rate="slow">` + strings.Join(digits, " "+pause) + `</prosody>` + pause
+
+	return "<speak>" + fmt.Sprintf(text, spokenCode) + "</speak>", language
+}
+
+func (s *userManagementServer) sendVoiceVerification(phoneNumber, code, language string) error {
+	to, err := phone.Normalize(phoneNumber, "")
+	if err != nil {
+		return fmt.Errorf("invalid phone number: %w", err)
+	}
+
+	speech, speechLanguage := voiceSpeech(code, language)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = voiceProvider.PlaceCall(ctx, VoiceCall{
+		To:       to,
+		From:     os.Getenv(ENV_VOICE_CALLER_ID),
+		Language: speechLanguage,
+		Speech:   speech,
+	})
+	if err != nil {
+		log.Printf("Failed to place verification call to %s: %v", maskPhoneNumber(to), err)
+		return fmt.Errorf("failed to place verification call: %w", err)
+	}
+
+	log.Printf("Verification call placed to %s", maskPhoneNumber(to))
+	return nil
+}
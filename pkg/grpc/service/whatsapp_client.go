@@ -8,27 +8,47 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/phone"
+	"github.com/influenzanet/user-management-service/pkg/secrets"
+)
+
+// Default template the verification code is sent with
+const (
+	WHATSAPP_VERIFICATION_TEMPLATE          = "hello_world"
+	WHATSAPP_VERIFICATION_TEMPLATE_LANGUAGE = "en_US"
 )
 
 type WhatsAppClient struct {
-	baseURL       string
-	accessToken   string
-	phoneNumberID string
-	httpClient    *http.Client
+	baseURL              string
+	accessToken          *secrets.Rotating
+	phoneNumberID        string
+	verificationTemplate WhatsAppTemplateConfig
+	httpClient           *http.Client
 }
 
 type WhatsAppMessage struct {
-	To       string                 `json:"to"`
-	Type     string                 `json:"type"`
-	Template WhatsAppTemplate       `json:"template"`
+	MessagingProduct string `json:"messaging_product"`
+
+	To       string            `json:"to"`
+	Type     string            `json:"type"`
+	Template *WhatsAppTemplate `json:"template,omitempty"`
+	Text     *WhatsAppText     `json:"text,omitempty"`
+}
+
+// WhatsAppText is the body of a free-form text message, only deliverable within 24 hours of
+// the last message the user sent us.
+type WhatsAppText struct {
+	Body string `json:"body"`
 }
 
 type WhatsAppTemplate struct {
-	Name       string                    `json:"name"`
-	Language   WhatsAppLanguage          `json:"language"`
-	Components []WhatsAppTemplateComponent `json:"components"`
+	Name       string                      `json:"name"`
+	Language   WhatsAppLanguage            `json:"language"`
+	Components []WhatsAppTemplateComponent `json:"components,omitempty"`
 }
 
 type WhatsAppLanguage struct {
@@ -36,7 +56,10 @@ type WhatsAppLanguage struct {
 }
 
 type WhatsAppTemplateComponent struct {
-	Type       string                      `json:"type"`
+	Type string `json:"type"`
+	// For "button" components: "url" and the index of the button in the template
+	SubType    string                      `json:"sub_type,omitempty"`
+	Index      string                      `json:"index,omitempty"`
 	Parameters []WhatsAppTemplateParameter `json:"parameters"`
 }
 
@@ -67,148 +90,224 @@ type WhatsAppError struct {
 	} `json:"error"`
 }
 
-func NewWhatsAppClient() *WhatsAppClient {
+// NewWhatsAppClient returns a client sending from the business number of config.
+func NewWhatsAppClient(config InstanceWhatsAppConfig) *WhatsAppClient {
 	return &WhatsAppClient{
-		baseURL:       "https://graph.facebook.com/v19.0",
-		accessToken:   os.Getenv("WHATSAPP_API_TOKEN"),
-		phoneNumberID: os.Getenv("WHATSAPP_PHONE_NUMBER_ID"),
+		baseURL:              config.BaseURL,
+		accessToken:          config.accessToken,
+		phoneNumberID:        config.PhoneNumberID,
+		verificationTemplate: config.VerificationTemplate,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
 }
 
-func (w *WhatsAppClient) SendVerificationCode(ctx context.Context, phoneNumber, code string) error {
-	if w.accessToken == "" || w.phoneNumberID == "" {
+var (
+	whatsAppClientsMu sync.Mutex
+	whatsAppClients   = map[string]*WhatsAppClient{}
+)
+
+// whatsAppClientFor returns the client of instanceID, created on first use from the instance's
+// WhatsApp config.
+func whatsAppClientFor(instanceID string) *WhatsAppClient {
+	whatsAppClientsMu.Lock()
+	defer whatsAppClientsMu.Unlock()
+
+	client, ok := whatsAppClients[instanceID]
+	if !ok {
+		client = NewWhatsAppClient(whatsAppConfig.ForInstance(instanceID))
+		whatsAppClients[instanceID] = client
+	}
+	return client
+}
+
+// SendVerificationCode sends code with the verification template in language. The code is the
+// only body parameter: the text, including the validity of VERIFICATION_CODE_EXPIRY_MINUTES, is
+// part of the approved template. Authentication templates also get the code for their
+// copy-code button.
+func (w *WhatsAppClient) SendVerificationCode(ctx context.Context, phoneNumber, code, language string) error {
+	if w.accessToken.Value() == "" || w.phoneNumberID == "" {
 		return fmt.Errorf("WhatsApp API credentials not configured")
 	}
 
-	// Clean phone number (remove any formatting)
-	cleanPhone := w.cleanPhoneNumber(phoneNumber)
-	
+	to, err := phone.Normalize(phoneNumber, "")
+	if err != nil {
+		return fmt.Errorf("invalid phone number: %w", err)
+	}
+
+	if language == "" {
+		language = w.verificationTemplate.Language
+	}
+	components := []WhatsAppTemplateComponent{
+		{
+			Type:       "body",
+			Parameters: []WhatsAppTemplateParameter{{Type: "text", Text: code}},
+		},
+	}
+	if strings.EqualFold(w.verificationTemplate.Category, "AUTHENTICATION") {
+		components = append(components, WhatsAppTemplateComponent{
+			Type:       "button",
+			SubType:    "url",
+			Index:      "0",
+			Parameters: []WhatsAppTemplateParameter{{Type: "text", Text: code}},
+		})
+	}
+
 	message := WhatsAppMessage{
-		To:   cleanPhone,
+		To:   to,
 		Type: "template",
-		Template: WhatsAppTemplate{
-			Name: "hello_world",
-			Language: WhatsAppLanguage{
-				Code: "en_US",
-			},
-			Components: []WhatsAppTemplateComponent{
-				{
-					Type: "body",
-					Parameters: []WhatsAppTemplateParameter{
-						{
-							Type: "text",
-							Text: fmt.Sprintf("Il tuo codice di verifica InfluenzaNet è: %s. Questo codice scadrà tra 10 minuti.", code),
-						},
-					},
-				},
-			},
+		Template: &WhatsAppTemplate{
+			Name:       w.verificationTemplate.Name,
+			Language:   WhatsAppLanguage{Code: language},
+			Components: components,
 		},
 	}
 
 	return w.sendMessage(ctx, message)
 }
 
+// SendTemplate sends an approved template message in language with the given components.
+func (w *WhatsAppClient) SendTemplate(ctx context.Context, phoneNumber, templateName, language string, components []WhatsAppTemplateComponent) error {
+	if w.accessToken.Value() == "" || w.phoneNumberID == "" {
+		return fmt.Errorf("WhatsApp API credentials not configured")
+	}
+
+	to, err := phone.Normalize(phoneNumber, "")
+	if err != nil {
+		return fmt.Errorf("invalid phone number: %w", err)
+	}
+
+	return w.sendMessage(ctx, WhatsAppMessage{
+		To:   to,
+		Type: "template",
+		Template: &WhatsAppTemplate{
+			Name:       templateName,
+			Language:   WhatsAppLanguage{Code: language},
+			Components: components,
+		},
+	})
+}
+
+// SendText sends a free-form text message, e.g. replying to a message the user sent us.
+func (w *WhatsAppClient) SendText(ctx context.Context, phoneNumber, text string) error {
+	if w.accessToken.Value() == "" || w.phoneNumberID == "" {
+		return fmt.Errorf("WhatsApp API credentials not configured")
+	}
+
+	to, err := phone.Normalize(phoneNumber, "")
+	if err != nil {
+		return fmt.Errorf("invalid phone number: %w", err)
+	}
+
+	return w.sendMessage(ctx, WhatsAppMessage{
+		To:   to,
+		Type: "text",
+		Text: &WhatsAppText{Body: text},
+	})
+}
+
+// sendMessage posts the message. On 401 the access token is reloaded, as it may have been
+// rotated since it was last loaded, and the message is sent once more with the new one.
 func (w *WhatsAppClient) sendMessage(ctx context.Context, message WhatsAppMessage) error {
+	message.MessagingProduct = "whatsapp"
+	statusCode, err := w.postMessage(ctx, message)
+	if statusCode != http.StatusUnauthorized {
+		return err
+	}
+
+	previousToken := w.accessToken.Value()
+	if refreshErr := w.accessToken.Refresh(ctx); refreshErr != nil || w.accessToken.Value() == previousToken {
+		return err
+	}
+	log.Printf("WhatsApp access token reloaded, retrying")
+	_, err = w.postMessage(ctx, message)
+	return err
+}
+
+// postMessage sends the message with the current access token, returning the HTTP status of
+// the response (0 if there was none).
+func (w *WhatsAppClient) postMessage(ctx context.Context, message WhatsAppMessage) (int, error) {
 	jsonData, err := json.Marshal(message)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return 0, fmt.Errorf("failed to marshal message: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/%s/messages", w.baseURL, w.phoneNumberID)
-	
+
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", w.accessToken))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", w.accessToken.Value()))
 
-	log.Printf("Sending WhatsApp message to %s", message.To)
-	log.Printf("Request URL: %s", url)
-	log.Printf("Request Body: %s", string(jsonData))
+	// Bodies carry verification codes and login tokens: only the masked recipient is logged
+	log.Printf("Sending WhatsApp message to %s", maskPhoneNumber(message.To))
 
 	resp, err := w.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return 0, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	log.Printf("WhatsApp API Response Status: %d", resp.StatusCode)
-	log.Printf("WhatsApp API Response Body: %s", string(body))
-
 	if resp.StatusCode != http.StatusOK {
 		var whatsappError WhatsAppError
 		if err := json.Unmarshal(body, &whatsappError); err == nil {
-			return fmt.Errorf("WhatsApp API error: %s (code: %d)", 
+			return resp.StatusCode, fmt.Errorf("WhatsApp API error: %s (code: %d)",
 				whatsappError.Error.Message, whatsappError.Error.Code)
 		}
-		return fmt.Errorf("WhatsApp API error: status %d, body: %s", resp.StatusCode, string(body))
+		return resp.StatusCode, fmt.Errorf("WhatsApp API error: status %d", resp.StatusCode)
 	}
 
 	var response WhatsAppResponse
 	if err := json.Unmarshal(body, &response); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
+		return resp.StatusCode, fmt.Errorf("failed to parse response: %w", err)
 	}
 
 	if len(response.Messages) == 0 {
-		return fmt.Errorf("no message ID returned from WhatsApp API")
+		return resp.StatusCode, fmt.Errorf("no message ID returned from WhatsApp API")
 	}
 
 	log.Printf("WhatsApp message sent successfully. Message ID: %s", response.Messages[0].ID)
-	return nil
-}
-
-func (w *WhatsAppClient) cleanPhoneNumber(phoneNumber string) string {
-	// Remove any non-digit characters except the leading +
-	cleaned := ""
-	for i, char := range phoneNumber {
-		if i == 0 && char == '+' {
-			cleaned += string(char)
-		} else if char >= '0' && char <= '9' {
-			cleaned += string(char)
-		}
-	}
-	return cleaned
+	return resp.StatusCode, nil
 }
 
-func (w *WhatsAppClient) SendWithRetry(ctx context.Context, phoneNumber, code string, maxRetries int) error {
+func (w *WhatsAppClient) SendWithRetry(ctx context.Context, phoneNumber, code, language string, maxRetries int) error {
 	var lastErr error
-	
+
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			// Exponential backoff: 30s, 1m, 2m
 			delays := []time.Duration{30 * time.Second, 60 * time.Second, 120 * time.Second}
-			delay := delays[attempt-1]
-			if attempt-1 >= len(delays) {
-				delay = delays[len(delays)-1]
+			delay := delays[len(delays)-1]
+			if attempt-1 < len(delays) {
+				delay = delays[attempt-1]
 			}
-			
+
 			log.Printf("Retrying WhatsApp send in %v (attempt %d/%d)", delay, attempt+1, maxRetries+1)
-			
+
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
 			case <-time.After(delay):
 			}
 		}
-		
-		err := w.SendVerificationCode(ctx, phoneNumber, code)
+
+		err := w.SendVerificationCode(ctx, phoneNumber, code, language)
 		if err == nil {
 			return nil
 		}
-		
+
 		lastErr = err
 		log.Printf("WhatsApp send attempt %d failed: %v", attempt+1, err)
 	}
-	
+
 	return fmt.Errorf("failed to send WhatsApp message after %d attempts: %w", maxRetries+1, lastErr)
-}
\ No newline at end of file
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"log"
+	"os"
+
+	"github.com/influenzanet/user-management-service/pkg/secrets"
+	"github.com/influenzanet/user-management-service/pkg/whatsapp"
+	"gopkg.in/yaml.v2"
+)
+
+const ENV_WHATSAPP_CONFIG_FILE = "WHATSAPP_CONFIG_FILE"
+
+// WhatsAppTemplateConfig is an approved message template. Parameters of the template body are
+// filled in by the sender.
+type WhatsAppTemplateConfig struct {
+	Name     string `yaml:"name"`
+	Language string `yaml:"language"`
+	// Meta template category: "AUTHENTICATION", "UTILITY" or "MARKETING"
+	Category string `yaml:"category"`
+	// Languages the template is approved in, when sent in the user's language
+	Languages []string `yaml:"languages"`
+}
+
+// SMSConfig is the SMS sender of an instance.
+type SMSConfig struct {
+	// Alphanumeric sender ID or number the SMS are sent from
+	SenderID string `yaml:"senderId"`
+	APIKey   string `yaml:"apiKey"`
+}
+
+// InstanceWhatsAppConfig holds the business account, sender number and templates of an
+// instance.
+type InstanceWhatsAppConfig struct {
+	BaseURL           string `yaml:"baseUrl"`
+	PhoneNumberID     string `yaml:"phoneNumberId"`
+	AccessToken       string `yaml:"accessToken"`
+	AccessTokenSecret string `yaml:"accessTokenSecret"` // name in the secrets provider, if no AccessToken
+	BusinessAccountID string `yaml:"businessAccountId"`
+	// Number of PhoneNumberID in E.164 format, which users send reverse verification messages to
+	BusinessPhoneNumber string `yaml:"businessPhoneNumber"`
+	DefaultLanguage     string `yaml:"defaultLanguage"`
+	// Template the verification code is sent with
+	VerificationTemplate WhatsAppTemplateConfig `yaml:"verificationTemplate"`
+	// Verification code templates
+	MessageTemplates map[string]WhatsAppTemplateConfig `yaml:"messageTemplates"`
+	// Survey reminder, study invitation, newsletter and security notice templates, keyed by
+	// message type
+	NotificationTemplates map[string]WhatsAppTemplateConfig `yaml:"notificationTemplates"`
+	SMS                   SMSConfig                         `yaml:"sms"`
+	// Token echoed when the webhook of the instance's Meta app is registered, and the app secret
+	// its notifications are signed with. Each is the literal value or, if empty, the secret named
+	// by the *Secret field, falling back to WHATSAPP_WEBHOOK_VERIFY_TOKEN and WHATSAPP_APP_SECRET.
+	WebhookVerifyToken       string `yaml:"webhookVerifyToken"`
+	WebhookVerifyTokenSecret string `yaml:"webhookVerifyTokenSecret"`
+	AppSecret                string `yaml:"appSecret"`
+	AppSecretSecret          string `yaml:"appSecretSecret"`
+
+	// Resolved by ForInstance from AccessToken or AccessTokenSecret
+	accessToken *secrets.Rotating
+	// Resolved by ForInstance like accessToken
+	webhookVerifyToken *secrets.Rotating
+	appSecret          *secrets.Rotating
+}
+
+// WhatsAppConfig is the content of whatsapp-config.yaml, with per-instance overrides of the top
+// level defaults. ${VAR} references are expanded from the environment.
+type WhatsAppConfig struct {
+	WhatsApp struct {
+		InstanceWhatsAppConfig `yaml:",inline"`
+		Instances              map[string]InstanceWhatsAppConfig `yaml:"instances"`
+	} `yaml:"whatsapp"`
+}
+
+var whatsAppConfig = loadWhatsAppConfig(os.Getenv(ENV_WHATSAPP_CONFIG_FILE))
+
+func loadWhatsAppConfig(path string) WhatsAppConfig {
+	config := WhatsAppConfig{}
+	if path == "" {
+		return config
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read WhatsApp config %s: %v", path, err)
+		return config
+	}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(content))), &config); err != nil {
+		log.Printf("Failed to parse WhatsApp config %s: %v", path, err)
+		return WhatsAppConfig{}
+	}
+	return config
+}
+
+// literalOrSecret resolves a credential of an instance: its literal value, or else its secret,
+// or else the default literal value, or else the default secret, falling back to envName.
+func literalOrSecret(value, secretName, defaultValue, defaultSecretName, envName string) *secrets.Rotating {
+	if value == "" && secretName == "" {
+		value = defaultValue
+	}
+	if value != "" {
+		return secrets.Fixed(value)
+	}
+	return rotatingSecret(nonEmptyStrings(secretName, defaultSecretName, envName)...)
+}
+
+// webhookInstances returns the settings of every configured instance and the defaults, whose
+// Meta apps may send webhook notifications.
+func (c WhatsAppConfig) webhookInstances() []InstanceWhatsAppConfig {
+	configs := []InstanceWhatsAppConfig{c.ForInstance("")}
+	for instanceID := range c.WhatsApp.Instances {
+		configs = append(configs, c.ForInstance(instanceID))
+	}
+	return configs
+}
+
+// ForInstance returns the settings of instanceID, falling back to the defaults for unset values,
+// and for unset credentials to the environment of single instance deployments. The access token
+// is the instance's literal token, or else its token secret, falling back to the default token
+// and to the WHATSAPP_API_TOKEN secret.
+func (c WhatsAppConfig) ForInstance(instanceID string) InstanceWhatsAppConfig {
+	defaults := c.WhatsApp.InstanceWhatsAppConfig
+	config := c.WhatsApp.Instances[instanceID]
+	if config.BaseURL == "" {
+		config.BaseURL = firstNonEmpty(defaults.BaseURL, os.Getenv(ENV_WHATSAPP_GRAPH_API_URL), whatsapp.DefaultAPIURL)
+	}
+	if config.PhoneNumberID == "" {
+		config.PhoneNumberID = firstNonEmpty(defaults.PhoneNumberID, os.Getenv("WHATSAPP_PHONE_NUMBER_ID"))
+	}
+	if config.AccessToken == "" && config.AccessTokenSecret == "" {
+		config.AccessToken = defaults.AccessToken
+	}
+	if config.AccessToken != "" {
+		config.accessToken = secrets.Fixed(config.AccessToken)
+	} else {
+		config.accessToken = rotatingSecret(nonEmptyStrings(config.AccessTokenSecret, defaults.AccessTokenSecret, ENV_WHATSAPP_API_TOKEN)...)
+	}
+	config.webhookVerifyToken = literalOrSecret(config.WebhookVerifyToken, config.WebhookVerifyTokenSecret, defaults.WebhookVerifyToken, defaults.WebhookVerifyTokenSecret, ENV_WHATSAPP_WEBHOOK_VERIFY_TOKEN)
+	config.appSecret = literalOrSecret(config.AppSecret, config.AppSecretSecret, defaults.AppSecret, defaults.AppSecretSecret, ENV_WHATSAPP_APP_SECRET)
+	if config.BusinessAccountID == "" {
+		config.BusinessAccountID = firstNonEmpty(defaults.BusinessAccountID, os.Getenv(ENV_WHATSAPP_BUSINESS_ACCOUNT_ID))
+	}
+	if config.BusinessPhoneNumber == "" {
+		config.BusinessPhoneNumber = firstNonEmpty(defaults.BusinessPhoneNumber, os.Getenv(ENV_WHATSAPP_BUSINESS_PHONE_NUMBER))
+	}
+	if config.DefaultLanguage == "" {
+		config.DefaultLanguage = defaults.DefaultLanguage
+	}
+	if config.VerificationTemplate.Name == "" {
+		config.VerificationTemplate = defaults.VerificationTemplate
+	}
+	if config.VerificationTemplate.Name == "" {
+		config.VerificationTemplate = WhatsAppTemplateConfig{
+			Name:     WHATSAPP_VERIFICATION_TEMPLATE,
+			Language: WHATSAPP_VERIFICATION_TEMPLATE_LANGUAGE,
+		}
+	}
+	if config.MessageTemplates == nil {
+		config.MessageTemplates = defaults.MessageTemplates
+	}
+	if config.NotificationTemplates == nil {
+		config.NotificationTemplates = defaults.NotificationTemplates
+	}
+	if config.SMS.SenderID == "" {
+		config.SMS.SenderID = defaults.SMS.SenderID
+	}
+	if config.SMS.APIKey == "" {
+		config.SMS.APIKey = defaults.SMS.APIKey
+	}
+	return config
+}
+
+// instancesForPhoneNumberID returns the instances sending from the business number
+// phoneNumberID, i.e. the instances an inbound message to that number may concern.
+func (c WhatsAppConfig) instancesForPhoneNumberID(phoneNumberID string) []string {
+	instanceIDs := []string{}
+	for _, instanceID := range whatsAppInstanceIDs() {
+		if phoneNumberID == "" || c.ForInstance(instanceID).PhoneNumberID == phoneNumberID {
+			instanceIDs = append(instanceIDs, instanceID)
+		}
+	}
+	return instanceIDs
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// nonEmptyStrings returns the distinct non-empty values, in order.
+func nonEmptyStrings(values ...string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value != "" && !containsString(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// notificationTemplate returns the template of messageType and the language to send it in, see
+// templateLanguage. ok is false if none is usable.
+func (c InstanceWhatsAppConfig) notificationTemplate(messageType, language string) (template WhatsAppTemplateConfig, templateLanguage string, ok bool) {
+	template, found := c.NotificationTemplates[messageType]
+	if !found || template.Name == "" {
+		return WhatsAppTemplateConfig{}, "", false
+	}
+	templateLanguage, ok = c.templateLanguage(template, language)
+	if !ok {
+		return WhatsAppTemplateConfig{}, "", false
+	}
+	return template, templateLanguage, true
+}
+
+// templateLanguage returns the language to send template in: the user's language if the
+// template is configured and approved in it, or else the first approved of the template's and
+// the default language. ok is false if none is usable.
+func (c InstanceWhatsAppConfig) templateLanguage(template WhatsAppTemplateConfig, language string) (string, bool) {
+	registry := whatsAppTemplatesFor(c)
+	candidates := []string{}
+	if language != "" && containsString(template.Languages, language) {
+		candidates = append(candidates, language)
+	}
+	candidates = append(candidates, template.Language, c.DefaultLanguage, "en")
+	for _, candidate := range candidates {
+		if candidate != "" && registry.usable(template.Name, candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
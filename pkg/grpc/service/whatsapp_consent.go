@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	loggingAPI "github.com/influenzanet/logging-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Where a consent was given or revoked
+const (
+	CONSENT_SOURCE_PHONE_VERIFICATION = "phone-verification"
+	CONSENT_SOURCE_SETTINGS           = "settings"
+	CONSENT_SOURCE_INBOUND_OPT_OUT    = "inbound-opt-out"
+	CONSENT_SOURCE_PHONE_REMOVED      = "phone-removed"
+)
+
+const (
+	LOG_EVENT_WHATSAPP_CONSENT_GRANTED = "WHATSAPP_CONSENT_GRANTED"
+	LOG_EVENT_WHATSAPP_CONSENT_REVOKED = "WHATSAPP_CONSENT_REVOKED"
+)
+
+// Comma separated instances searched for the sender of an inbound opt-out. Defaults to the
+// instances of the WhatsApp and phone verification configs.
+const ENV_WHATSAPP_INSTANCE_IDS = "WHATSAPP_INSTANCE_IDS"
+
+// Replies revoking WhatsApp consent, compared case-insensitively without punctuation
+var whatsAppOptOutKeywords = []string{"STOP", "ANNULLA"}
+
+var errNoWhatsAppConsent = errors.New("no WhatsApp consent for this phone number")
+
+// activeMessagingConsent returns the active consent for channel at phoneNumber, if any.
+func activeMessagingConsent(consents []models.MessagingConsent, channel, phoneNumber string) *models.MessagingConsent {
+	for i := range consents {
+		if consents[i].Channel == channel && consents[i].PhoneNumber == phoneNumber && consents[i].Active() {
+			return &consents[i]
+		}
+	}
+	return nil
+}
+
+// hasWhatsAppConsent reports whether the user opted in to WhatsApp messages at phoneNumber.
+func (s *userManagementServer) hasWhatsAppConsent(instanceID, userID, phoneNumber string) bool {
+	if userID == "" {
+		return false
+	}
+	consents, err := s.userDBservice.GetMessagingConsents(instanceID, userID)
+	if err != nil {
+		log.Printf("Error reading messaging consents: %v", err)
+		return false
+	}
+	return activeMessagingConsent(consents, PHONE_CHANNEL_WHATSAPP, phoneNumber) != nil
+}
+
+// whatsAppAllowedForAttempt reports whether the code of attempt may be sent over WhatsApp to
+// phoneNumber: the user opted in when starting the verification of that number, or earlier.
+func (s *userManagementServer) whatsAppAllowedForAttempt(attempt *VerificationAttempt, phoneNumber string) bool {
+	if attempt.WhatsAppOptIn && phoneNumber == attempt.PhoneNumber {
+		return true
+	}
+	return s.hasWhatsAppConsent(attempt.InstanceID, attempt.UserID, phoneNumber)
+}
+
+// grantWhatsAppConsent records the user's opt-in to WhatsApp messages at phoneNumber, with the
+// policy version of the instance the user agreed to.
+func (s *userManagementServer) grantWhatsAppConsent(instanceID, userID, phoneNumber, source, clientIP string) error {
+	err := s.userDBservice.AddMessagingConsent(instanceID, userID, models.MessagingConsent{
+		Channel:       PHONE_CHANNEL_WHATSAPP,
+		PhoneNumber:   phoneNumber,
+		GrantedAt:     time.Now().Unix(),
+		Source:        source,
+		PolicyVersion: phoneVerificationConfig.ForInstance(instanceID).WhatsAppConsentPolicyVersion,
+		IPAddress:     clientIP,
+	})
+	if err != nil {
+		return err
+	}
+	s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_WHATSAPP_CONSENT_GRANTED, "source: "+source)
+	return nil
+}
+
+// revokeWhatsAppConsent revokes the user's opt-in at phoneNumber and stops sending WhatsApp
+// codes to it for their pending verifications.
+func (s *userManagementServer) revokeWhatsAppConsent(instanceID, userID, phoneNumber, source string) error {
+	revoked, err := s.userDBservice.RevokeMessagingConsent(instanceID, userID, PHONE_CHANNEL_WHATSAPP, phoneNumber, source)
+	if err != nil {
+		return err
+	}
+	verificationAttempts.updateWhere(func(attempt *VerificationAttempt) bool {
+		return attempt.InstanceID == instanceID && attempt.UserID == userID && attempt.PhoneNumber == phoneNumber
+	}, func(attempt *VerificationAttempt) {
+		attempt.WhatsAppOptIn = false
+	})
+	if revoked {
+		s.SaveLogEvent(instanceID, userID, loggingAPI.LogEventType_SECURITY, LOG_EVENT_WHATSAPP_CONSENT_REVOKED, "source: "+source)
+	}
+	return nil
+}
+
+// SetWhatsAppConsent grants or revokes the consent of the logged in user to WhatsApp messages at
+// one of their phone numbers.
+func (s *userManagementServer) SetWhatsAppConsent(ctx context.Context, req *api.SetWhatsAppConsentRequest) (*api.PhoneNumberList, error) {
+	if req == nil || req.Token == "" || req.ContactId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	user, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	contact := findPhoneContact(user, req.ContactId)
+	if contact == nil {
+		return nil, status.Error(codes.NotFound, "phone number not found")
+	}
+
+	if req.Granted {
+		if contact.ConfirmedAt <= 0 {
+			return nil, status.Error(codes.FailedPrecondition, "phone number not verified")
+		}
+		err = s.grantWhatsAppConsent(instanceID, userID, contact.Phone, CONSENT_SOURCE_SETTINGS, req.ClientIp)
+	} else {
+		err = s.revokeWhatsAppConsent(instanceID, userID, contact.Phone, CONSENT_SOURCE_SETTINGS)
+	}
+	if err != nil {
+		log.Printf("Error updating WhatsApp consent: %v", err)
+		return nil, status.Error(codes.Internal, "failed to update WhatsApp consent")
+	}
+	return s.getPhoneNumberList(instanceID, userID)
+}
+
+// isWhatsAppOptOut reports whether an inbound message asks to stop WhatsApp messages.
+func isWhatsAppOptOut(text string) bool {
+	keyword := strings.TrimFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})
+	for _, optOut := range whatsAppOptOutKeywords {
+		if strings.EqualFold(keyword, optOut) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWhatsAppOptOut revokes the WhatsApp consent of every user verified with the sender's
+// number in the instances sending from the business number phoneNumberID, and confirms it to
+// the sender.
+func (s *userManagementServer) handleWhatsAppOptOut(phoneNumberID, phoneNumber string) {
+	for _, instanceID := range whatsAppConfig.instancesForPhoneNumberID(phoneNumberID) {
+		userIDs, err := s.userDBservice.FindUserIDsByPhoneNumber(instanceID, phoneNumber)
+		if err != nil {
+			log.Printf("Error finding users by phone number in %s: %v", instanceID, err)
+			continue
+		}
+		for _, userID := range userIDs {
+			if err := s.revokeWhatsAppConsent(instanceID, userID, phoneNumber, CONSENT_SOURCE_INBOUND_OPT_OUT); err != nil {
+				log.Printf("Error revoking WhatsApp consent: %v", err)
+			}
+		}
+	}
+	s.replyWhatsApp(phoneNumberID, phoneNumber, "You will no longer receive WhatsApp messages from InfluenzaNet. You can opt in again in your account settings.")
+}
+
+// whatsAppInstanceIDs returns the instances inbound WhatsApp messages may concern.
+func whatsAppInstanceIDs() []string {
+	if value := os.Getenv(ENV_WHATSAPP_INSTANCE_IDS); value != "" {
+		var instanceIDs []string
+		for _, instanceID := range strings.Split(value, ",") {
+			if instanceID = strings.TrimSpace(instanceID); instanceID != "" {
+				instanceIDs = append(instanceIDs, instanceID)
+			}
+		}
+		return instanceIDs
+	}
+	instanceIDs := make([]string, 0, len(whatsAppConfig.WhatsApp.Instances)+len(phoneVerificationConfig.Instances))
+	for instanceID := range whatsAppConfig.WhatsApp.Instances {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	for instanceID := range phoneVerificationConfig.Instances {
+		if _, ok := whatsAppConfig.WhatsApp.Instances[instanceID]; !ok {
+			instanceIDs = append(instanceIDs, instanceID)
+		}
+	}
+	return instanceIDs
+}
@@ -0,0 +1,27 @@
+package service
+
+import "testing"
+
+func TestIsWhatsAppOptOut(t *testing.T) {
+	tests := []struct {
+		text   string
+		optOut bool
+	}{
+		{"STOP", true},
+		{"stop", true},
+		{" Stop! ", true},
+		{"ANNULLA", true},
+		{"annulla.", true},
+		{"please stop", false},
+		{"STOPPED", false},
+		{"123456", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if optOut := isWhatsAppOptOut(tt.text); optOut != tt.optOut {
+				t.Errorf("expected %v, got %v", tt.optOut, optOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"github.com/influenzanet/user-management-service/pkg/reminders"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Message types that can be delivered over WhatsApp, see notificationTemplates in
+// whatsapp-config.yaml. Newsletters (NOTIFICATION_TYPE_NEWSLETTER) can be sent too, without a
+// login link.
+const (
+	REMINDER_TYPE_WEEKLY           = "weekly-reminder"
+	REMINDER_TYPE_STUDY_INVITATION = "study-invitation"
+)
+
+const TOKEN_PURPOSE_SURVEY_LOGIN = "survey-login"
+
+const (
+	// Users handled by one SendWhatsAppReminders call, which keeps the call well within the
+	// scheduler's deadline
+	WHATSAPP_REMINDER_BATCH_SIZE = reminders.BATCH_SIZE
+	// Reminders sent at the same time
+	WHATSAPP_REMINDER_WORKERS = 8
+)
+
+// Lifetime of the survey login token in the reminder link, as a duration ("168h") or minutes
+const ENV_INVITATION_TOKEN_LIFETIME = "INVITATION_TOKEN_LIFETIME"
+
+var invitationTokenLifetime = durationFromEnv(ENV_INVITATION_TOKEN_LIFETIME, 168*time.Hour)
+
+var errNoWhatsAppRecipient = errors.New("user cannot be reached on WhatsApp")
+
+func durationFromEnv(envName string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(envName)
+	if value == "" {
+		return defaultValue
+	}
+	if minutes, err := strconv.Atoi(value); err == nil && minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s value %q, using default", envName, value)
+		return defaultValue
+	}
+	return duration
+}
+
+// SendWhatsAppReminders is called by the message scheduler to deliver survey reminders or study
+// invitations as WhatsApp templates linking to the survey. Without user IDs, weekly reminders
+// go to the users whose assigned weekday (see WEEKDAY_ASSIGNATION_WEIGHTS) is today. Users who
+// chose another channel for the message type, or have no verified, consenting phone accepting
+// WhatsApp, are returned as skipped, for the scheduler to email them instead.
+//
+// A call handles at most WHATSAPP_REMINDER_BATCH_SIZE users: longer lists of user IDs are split
+// by the scheduler, and weekly reminders are paged with next_page_token until it is empty.
+func (s *userManagementServer) SendWhatsAppReminders(ctx context.Context, req *api.SendWhatsAppRemindersRequest) (*api.SendWhatsAppRemindersResponse, error) {
+	if req == nil || req.InstanceId == "" || req.MessageType == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	switch req.MessageType {
+	case REMINDER_TYPE_WEEKLY, REMINDER_TYPE_STUDY_INVITATION, NOTIFICATION_TYPE_NEWSLETTER:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported message type: %s", req.MessageType)
+	}
+	if req.MessageType != REMINDER_TYPE_WEEKLY && len(req.UserIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user ids required for this message type")
+	}
+	if len(req.UserIds) > WHATSAPP_REMINDER_BATCH_SIZE {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d user ids per call", WHATSAPP_REMINDER_BATCH_SIZE)
+	}
+	if _, _, ok := whatsAppConfig.ForInstance(req.InstanceId).notificationTemplate(req.MessageType, ""); !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "no approved WhatsApp template configured for %s", req.MessageType)
+	}
+
+	var users []models.User
+	resp := &api.SendWhatsAppRemindersResponse{}
+	if len(req.UserIds) > 0 {
+		for _, userID := range req.UserIds {
+			user, err := s.userDBservice.GetUser(req.InstanceId, userID)
+			if err != nil {
+				log.Printf("WhatsApp reminder: cannot load user %s: %v", userID, err)
+				resp.FailedUserIds = append(resp.FailedUserIds, userID)
+				continue
+			}
+			users = append(users, user)
+		}
+	} else {
+		// The weekday is kept in the page token, so a run going past midnight ends on the same day
+		weekday, afterID, err := parseReminderPageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		weekdayUsers, err := s.userDBservice.FindUsersForWeeklyReminder(req.InstanceId, weekday, afterID, WHATSAPP_REMINDER_BATCH_SIZE)
+		if err != nil {
+			log.Printf("Error finding users for weekly reminders: %v", err)
+			return nil, status.Error(codes.Internal, "failed to find users")
+		}
+		users = weekdayUsers
+		if len(users) == WHATSAPP_REMINDER_BATCH_SIZE {
+			resp.NextPageToken = fmt.Sprintf("%d:%s", weekday, users[len(users)-1].ID.Hex())
+		}
+	}
+
+	s.sendWhatsAppReminderBatch(ctx, req, users, resp)
+	return resp, nil
+}
+
+// sendWhatsAppReminderBatch sends the reminders of users, WHATSAPP_REMINDER_WORKERS at a time,
+// and records the outcome of each in resp. Users not sent to before ctx ends are failed, for
+// the scheduler to retry them.
+func (s *userManagementServer) sendWhatsAppReminderBatch(ctx context.Context, req *api.SendWhatsAppRemindersRequest, users []models.User, resp *api.SendWhatsAppRemindersResponse) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, WHATSAPP_REMINDER_WORKERS)
+	for _, user := range users {
+		user := user
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			userID := user.ID.Hex()
+			err := ctx.Err()
+			switch {
+			case err != nil:
+			case req.MessageType == NOTIFICATION_TYPE_NEWSLETTER:
+				err = s.sendWhatsAppNewsletter(ctx, req.InstanceId, user)
+			default:
+				err = s.sendWhatsAppReminder(ctx, req.InstanceId, user, req.MessageType, req.StudyKey, req.SurveyKey)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				resp.SentUserIds = append(resp.SentUserIds, userID)
+			case errors.Is(err, errNoWhatsAppRecipient), errors.Is(err, errWhatsAppTemplateUnavailable):
+				resp.SkippedUserIds = append(resp.SkippedUserIds, userID)
+			default:
+				log.Printf("Error sending WhatsApp reminder to user %s: %v", userID, err)
+				resp.FailedUserIds = append(resp.FailedUserIds, userID)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// parseReminderPageToken returns the weekday and last user of the weekly reminder page token,
+// or today and no user for the first page.
+func parseReminderPageToken(token string) (int32, string, error) {
+	if token == "" {
+		return int32(time.Now().Weekday()), "", nil
+	}
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("malformed page token")
+	}
+	weekday, err := strconv.Atoi(parts[0])
+	if err != nil || weekday < 0 || weekday > 6 {
+		return 0, "", fmt.Errorf("malformed page token")
+	}
+	return int32(weekday), parts[1], nil
+}
+
+// sendWhatsAppNewsletter sends the newsletter template to the user's WhatsApp number, if the
+// user chose WhatsApp for newsletters. Newsletters are marketing messages, forwarded and
+// shared at will: they carry no login token.
+func (s *userManagementServer) sendWhatsAppNewsletter(ctx context.Context, instanceID string, user models.User) error {
+	if s.notificationChannel(instanceID, user.ID.Hex(), NOTIFICATION_TYPE_NEWSLETTER) != PHONE_CHANNEL_WHATSAPP {
+		return errNoWhatsAppRecipient
+	}
+	contact := s.notificationPhoneContact(instanceID, user, PHONE_CHANNEL_WHATSAPP)
+	if contact == nil {
+		return errNoWhatsAppRecipient
+	}
+	template, language, ok := whatsAppConfig.ForInstance(instanceID).notificationTemplate(NOTIFICATION_TYPE_NEWSLETTER, user.Account.PreferredLanguage)
+	if !ok {
+		return errWhatsAppTemplateUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	return whatsAppClientFor(instanceID).SendTemplate(ctx, contact.Phone, template.Name, language, nil)
+}
+
+// sendWhatsAppReminder sends the reminder or invitation template of messageType to the user's
+// WhatsApp number, with a survey login token as the suffix of its URL button. Reminders go over
+// WhatsApp unless the user chose email for them.
+func (s *userManagementServer) sendWhatsAppReminder(ctx context.Context, instanceID string, user models.User, messageType, studyKey, surveyKey string) error {
+	userID := user.ID.Hex()
+	switch s.notificationChannel(instanceID, userID, messageType) {
+	case PHONE_CHANNEL_WHATSAPP, "":
+	default:
+		return errNoWhatsAppRecipient
+	}
+	contact := s.notificationPhoneContact(instanceID, user, PHONE_CHANNEL_WHATSAPP)
+	if contact == nil {
+		return errNoWhatsAppRecipient
+	}
+	template, language, ok := whatsAppConfig.ForInstance(instanceID).notificationTemplate(messageType, user.Account.PreferredLanguage)
+	if !ok {
+		return errWhatsAppTemplateUnavailable
+	}
+
+	info := map[string]string{}
+	if studyKey != "" {
+		info["studyKey"] = studyKey
+	}
+	if surveyKey != "" {
+		info["surveyKey"] = surveyKey
+	}
+	loginToken, err := s.globalDBService.AddTempToken(models.TempToken{
+		UserID:     userID,
+		InstanceID: instanceID,
+		Purpose:    TOKEN_PURPOSE_SURVEY_LOGIN,
+		Info:       info,
+		Expiration: time.Now().Add(invitationTokenLifetime).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	return whatsAppClientFor(instanceID).SendTemplate(ctx, contact.Phone, template.Name, language, []WhatsAppTemplateComponent{
+		{
+			Type:       "button",
+			SubType:    "url",
+			Index:      "0",
+			Parameters: []WhatsAppTemplateParameter{{Type: "text", Text: loginToken}},
+		},
+	})
+}
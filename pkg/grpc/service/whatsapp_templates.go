@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/whatsapp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ENV_WHATSAPP_BUSINESS_ACCOUNT_ID = "WHATSAPP_BUSINESS_ACCOUNT_ID"
+	ENV_WHATSAPP_GRAPH_API_URL       = "WHATSAPP_GRAPH_API_URL" // e.g. a fake Business Management API server
+	// "true" refuses to start when a configured template is not approved
+	ENV_WHATSAPP_TEMPLATES_STRICT = "WHATSAPP_TEMPLATES_STRICT"
+	// How often template statuses are refreshed, as a duration. Default 1h.
+	ENV_WHATSAPP_TEMPLATE_REFRESH_INTERVAL = "WHATSAPP_TEMPLATE_REFRESH_INTERVAL"
+)
+
+var errWhatsAppTemplateUnavailable = errors.New("WhatsApp template not approved")
+
+// whatsAppTemplateRegistry caches the review status of the templates of the business account.
+// Until statuses could be loaded every template is assumed usable, so sends are not blocked by
+// an unreachable or unconfigured Business Management API.
+type whatsAppTemplateRegistry struct {
+	client *whatsapp.TemplateClient
+
+	mu          sync.RWMutex
+	statuses    map[string]string // "<name>/<language>" -> status
+	expirations map[string]int    // "<name>/<language>" -> code validity stated by the template
+	loaded      bool
+}
+
+var (
+	whatsAppTemplateRegistriesMu sync.Mutex
+	// Registries of the business accounts of the instances, keyed by business account ID
+	whatsAppTemplateRegistries = map[string]*whatsAppTemplateRegistry{}
+)
+
+// whatsAppTemplatesFor returns the registry of the business account of config.
+func whatsAppTemplatesFor(config InstanceWhatsAppConfig) *whatsAppTemplateRegistry {
+	whatsAppTemplateRegistriesMu.Lock()
+	defer whatsAppTemplateRegistriesMu.Unlock()
+
+	registry, ok := whatsAppTemplateRegistries[config.BusinessAccountID]
+	if !ok {
+		registry = &whatsAppTemplateRegistry{
+			client: whatsapp.NewTemplateClient(config.BaseURL, config.BusinessAccountID, config.accessToken.Value),
+		}
+		whatsAppTemplateRegistries[config.BusinessAccountID] = registry
+	}
+	return registry
+}
+
+// startWhatsAppTemplateChecks checks that every template referenced by the WhatsApp config of
+// an instance is approved in the languages it is sent in, then keeps the statuses up to date.
+// Problems are logged, and returned as an error with WHATSAPP_TEMPLATES_STRICT.
+func startWhatsAppTemplateChecks() error {
+	problems := []string{}
+	refreshed := map[*whatsAppTemplateRegistry]bool{}
+	for _, instanceID := range append([]string{""}, whatsAppInstanceIDs()...) {
+		config := whatsAppConfig.ForInstance(instanceID)
+		registry := whatsAppTemplatesFor(config)
+		if !registry.client.Configured() {
+			log.Printf("No WhatsApp business account configured for instance %q, templates are not validated", instanceID)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		instanceProblems, err := registry.validate(ctx, config.referencedTemplates())
+		cancel()
+		if err != nil {
+			instanceProblems = append(instanceProblems, err.Error())
+		}
+		for _, problem := range instanceProblems {
+			problems = append(problems, fmt.Sprintf("instance %q: %s", instanceID, problem))
+		}
+		refreshed[registry] = true
+	}
+	for _, problem := range problems {
+		log.Printf("WhatsApp template check: %s", problem)
+	}
+	if len(problems) > 0 && os.Getenv(ENV_WHATSAPP_TEMPLATES_STRICT) == "true" {
+		return fmt.Errorf("WhatsApp templates not ready (%d problems)", len(problems))
+	}
+
+	interval := durationFromEnv(ENV_WHATSAPP_TEMPLATE_REFRESH_INTERVAL, time.Hour)
+	for registry := range refreshed {
+		go registry.refreshLoop(interval)
+	}
+	return nil
+}
+
+func templateKey(name, language string) string {
+	return name + "/" + language
+}
+
+// refresh reloads the statuses of all templates of the account.
+func (r *whatsAppTemplateRegistry) refresh(ctx context.Context) error {
+	templates, err := r.client.ListTemplates(ctx)
+	if err != nil {
+		return err
+	}
+
+	statuses := make(map[string]string, len(templates))
+	expirations := map[string]int{}
+	for _, template := range templates {
+		key := templateKey(template.Name, template.Language)
+		statuses[key] = template.Status
+		if minutes := template.CodeExpirationMinutes(); minutes > 0 {
+			expirations[key] = minutes
+		}
+	}
+
+	r.mu.Lock()
+	r.statuses = statuses
+	r.expirations = expirations
+	r.loaded = true
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *whatsAppTemplateRegistry) refreshLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := r.refresh(ctx); err != nil {
+			log.Printf("Error refreshing WhatsApp template statuses: %v", err)
+		}
+		cancel()
+	}
+}
+
+// status returns the status of the template in language, "" if the account has none, and
+// whether statuses have been loaded.
+func (r *whatsAppTemplateRegistry) status(name, language string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.statuses[templateKey(name, language)], r.loaded
+}
+
+// codeExpirationMinutes returns the code validity the template states in language, 0 if none.
+func (r *whatsAppTemplateRegistry) codeExpirationMinutes(name, language string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.expirations[templateKey(name, language)]
+}
+
+// usable reports whether the template can be sent in language.
+func (r *whatsAppTemplateRegistry) usable(name, language string) bool {
+	templateStatus, loaded := r.status(name, language)
+	return !loaded || templateStatus == whatsapp.TemplateStatusApproved
+}
+
+// validate refreshes the statuses and describes every referenced template that is not approved.
+func (r *whatsAppTemplateRegistry) validate(ctx context.Context, referenced []whatsAppTemplateRef) ([]string, error) {
+	if err := r.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("cannot list templates: %w", err)
+	}
+
+	problems := []string{}
+	for _, ref := range referenced {
+		templateStatus, _ := r.status(ref.Name, ref.Language)
+		switch templateStatus {
+		case whatsapp.TemplateStatusApproved:
+			if stated := r.codeExpirationMinutes(ref.Name, ref.Language); ref.CodeExpirationMinutes > 0 && stated > 0 && stated != ref.CodeExpirationMinutes {
+				problems = append(problems, fmt.Sprintf("%s (%s) states a code validity of %d minutes instead of %d", ref.Name, ref.Language, stated, ref.CodeExpirationMinutes))
+			}
+		case "":
+			problems = append(problems, fmt.Sprintf("%s (%s) does not exist", ref.Name, ref.Language))
+		default:
+			problems = append(problems, fmt.Sprintf("%s (%s) is %s", ref.Name, ref.Language, templateStatus))
+		}
+	}
+	return problems, nil
+}
+
+// whatsAppTemplateRef is a template in one language the service may send.
+type whatsAppTemplateRef struct {
+	Name     string
+	Language string
+	// Code validity the template must state, if it states one; 0 for templates without a code
+	CodeExpirationMinutes int
+}
+
+// referencedTemplates returns the templates of the config in every language they are sent in,
+// plus the verification code template, which must state the validity of the codes.
+func (c InstanceWhatsAppConfig) referencedTemplates() []whatsAppTemplateRef {
+	seen := map[string]bool{}
+	refs := []whatsAppTemplateRef{}
+	add := func(template WhatsAppTemplateConfig, codeExpirationMinutes int) {
+		for _, language := range append([]string{template.Language}, template.Languages...) {
+			if template.Name == "" || language == "" || seen[templateKey(template.Name, language)] {
+				continue
+			}
+			seen[templateKey(template.Name, language)] = true
+			refs = append(refs, whatsAppTemplateRef{Name: template.Name, Language: language, CodeExpirationMinutes: codeExpirationMinutes})
+		}
+	}
+
+	add(c.VerificationTemplate, VERIFICATION_CODE_EXPIRY_MINUTES)
+	for _, template := range c.MessageTemplates {
+		add(template, VERIFICATION_CODE_EXPIRY_MINUTES)
+	}
+	for _, template := range c.NotificationTemplates {
+		add(template, 0)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return templateKey(refs[i].Name, refs[i].Language) < templateKey(refs[j].Name, refs[j].Language)
+	})
+	return refs
+}
+
+// GetWhatsAppTemplateStatus lists the templates the admin's instance sends with their current
+// review status, for admins to spot paused or rejected ones.
+func (s *userManagementServer) GetWhatsAppTemplateStatus(ctx context.Context, req *api.WhatsAppTemplateStatusRequest) (*api.WhatsAppTemplateStatusList, error) {
+	if req == nil || req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+
+	userID, instanceID, err := s.ValidateToken(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	admin, err := s.userDBservice.GetUser(instanceID, userID)
+	if err != nil || !admin.HasRole(USER_ROLE_ADMIN) {
+		return nil, status.Error(codes.PermissionDenied, "not permitted")
+	}
+
+	config := whatsAppConfig.ForInstance(instanceID)
+	registry := whatsAppTemplatesFor(config)
+	if !registry.client.Configured() {
+		return nil, status.Error(codes.FailedPrecondition, "WhatsApp business account not configured")
+	}
+	if err := registry.refresh(ctx); err != nil {
+		log.Printf("Error listing WhatsApp templates: %v", err)
+		return nil, status.Error(codes.Unavailable, "failed to list WhatsApp templates")
+	}
+
+	list := &api.WhatsAppTemplateStatusList{}
+	for _, ref := range config.referencedTemplates() {
+		templateStatus, _ := registry.status(ref.Name, ref.Language)
+		list.Templates = append(list.Templates, &api.WhatsAppTemplateStatus{
+			Name:     ref.Name,
+			Language: ref.Language,
+			Status:   strings.ToUpper(templateStatus),
+			Usable:   templateStatus == whatsapp.TemplateStatusApproved,
+		})
+	}
+	return list, nil
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"github.com/influenzanet/user-management-service/pkg/phone"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// App secret the Cloud API signs webhook payloads with (X-Hub-Signature-256)
+	ENV_WHATSAPP_APP_SECRET = "WHATSAPP_APP_SECRET"
+	// Token configured in the Meta app dashboard, echoed when the webhook is registered
+	ENV_WHATSAPP_WEBHOOK_VERIFY_TOKEN = "WHATSAPP_WEBHOOK_VERIFY_TOKEN"
+)
+
+const WHATSAPP_SIGNATURE_PREFIX = "sha256="
+
+// Method of attempts whose code is not sent at all: it is shown on the website, and the user
+// sends it from the number being verified to our WhatsApp business number.
+const VERIFICATION_METHOD_WHATSAPP_REVERSE = "whatsapp-reverse"
+
+// WhatsAppWebhookPayload is the body of a Cloud API webhook notification. Only the fields
+// needed for inbound text messages are decoded.
+type WhatsAppWebhookPayload struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		ID      string `json:"id"`
+		Changes []struct {
+			Field string               `json:"field"`
+			Value WhatsAppWebhookValue `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type WhatsAppWebhookValue struct {
+	MessagingProduct string `json:"messaging_product"`
+	Metadata         struct {
+		DisplayPhoneNumber string `json:"display_phone_number"`
+		PhoneNumberID      string `json:"phone_number_id"`
+	} `json:"metadata"`
+	Contacts []struct {
+		WaID string `json:"wa_id"`
+	} `json:"contacts"`
+	Messages []WhatsAppInboundMessage `json:"messages"`
+}
+
+type WhatsAppInboundMessage struct {
+	From      string `json:"from"` // sender wa_id, the number in international format without "+"
+	ID        string `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Text      *struct {
+		Body string `json:"body"`
+	} `json:"text,omitempty"`
+}
+
+// VerifyWhatsAppWebhook answers the subscription request Meta sends when the webhook URL is
+// registered, by returning the challenge if the verify token matches.
+func (s *userManagementServer) VerifyWhatsAppWebhook(ctx context.Context, req *api.WhatsAppWebhookChallengeRequest) (*api.WhatsAppWebhookChallengeResponse, error) {
+	if req == nil || req.Mode != "subscribe" || req.Challenge == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	for _, config := range whatsAppConfig.webhookInstances() {
+		verifyToken := config.webhookVerifyToken.Value()
+		if verifyToken != "" && subtle.ConstantTimeCompare([]byte(verifyToken), []byte(req.VerifyToken)) == 1 {
+			return &api.WhatsAppWebhookChallengeResponse{Challenge: req.Challenge}, nil
+		}
+	}
+	return nil, status.Error(codes.PermissionDenied, "invalid verify token")
+}
+
+// HandleWhatsAppWebhook processes a webhook notification of a business number. Inbound text
+// messages are matched against pending verifications of the instances sending from the number
+// they were sent to: a user sending the code from the number being verified proves possession
+// of it (reverse verification). Other events are ignored.
+func (s *userManagementServer) HandleWhatsAppWebhook(ctx context.Context, req *api.WhatsAppWebhookRequest) (*api.ServiceStatus, error) {
+	if req == nil || len(req.Payload) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing arguments")
+	}
+	if !validWhatsAppSignature(req.Payload, req.Signature) {
+		return nil, status.Error(codes.PermissionDenied, "invalid webhook signature")
+	}
+
+	var payload WhatsAppWebhookPayload
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid payload")
+	}
+
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			if change.Field != "messages" {
+				continue
+			}
+			for _, message := range change.Value.Messages {
+				if message.Type != "text" || message.Text == nil {
+					continue
+				}
+				s.handleWhatsAppInboundText(change.Value.Metadata.PhoneNumberID, message.From, message.Text.Body)
+			}
+		}
+	}
+
+	return &api.ServiceStatus{
+		Status: api.ServiceStatus_NORMAL,
+		Msg:    "webhook processed",
+	}, nil
+}
+
+// validWhatsAppSignature checks the "sha256=<hex>" HMAC of the raw payload with the app secret
+// of each configured instance.
+func validWhatsAppSignature(payload []byte, signature string) bool {
+	if !strings.HasPrefix(signature, WHATSAPP_SIGNATURE_PREFIX) {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, WHATSAPP_SIGNATURE_PREFIX))
+	if err != nil {
+		return false
+	}
+	for _, config := range whatsAppConfig.webhookInstances() {
+		secret := config.appSecret.Value()
+		if secret == "" {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		if hmac.Equal(mac.Sum(nil), expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWhatsAppInboundText revokes the sender's consent on an opt-out keyword, or else completes
+// the pending verification of the sender's number whose
+// code the message contains. Wrong codes count as failed attempts of every pending
+// verification of that number; messages without digits are ignored. phoneNumberID is the
+// business number the message was sent to, which scopes it to the instances sending from it.
+func (s *userManagementServer) handleWhatsAppInboundText(phoneNumberID, waID, text string) {
+	sender, err := phone.Normalize("+"+waID, "")
+	if err != nil {
+		log.Printf("Ignoring WhatsApp message from invalid number: %v", err)
+		return
+	}
+	if isWhatsAppOptOut(text) {
+		s.handleWhatsAppOptOut(phoneNumberID, sender)
+		return
+	}
+
+	attempts := findReverseVerificationAttempts(phoneNumberID, sender)
+	if len(attempts) == 0 {
+		return
+	}
+
+	candidates := extractCodeCandidates(text)
+	if len(candidates) == 0 {
+		return
+	}
+	for _, attempt := range attempts {
+		if !containsCode(candidates, attempt.expectedReverseCode()) {
+			continue
+		}
+		attempt, exists := verificationAttempts.update(attempt.Token, func(attempt *VerificationAttempt) {
+			attempt.Attempts++
+		})
+		if !exists {
+			return
+		}
+		if attempt.AwaitingOldNumberProof {
+			attempt, err := s.completeOldNumberProof(attempt)
+			if err != nil {
+				log.Printf("Error sending verification: %v", err)
+				verificationAttempts.remove(attempt.Token)
+				return
+			}
+			if attempt.isReverseStep() {
+				s.replyWhatsApp(phoneNumberID, sender, "Thank you, your current number is confirmed. Please send the code now shown on the website from your new number.")
+				return
+			}
+			s.replyWhatsApp(phoneNumberID, sender, "Thank you, your current number is confirmed. Please enter the code sent to your new number on the website.")
+			return
+		}
+
+		message, err := s.completePhoneVerification(attempt)
+		if err != nil {
+			log.Printf("Error completing reverse WhatsApp verification: %v", err)
+			s.replyWhatsApp(phoneNumberID, sender, "Your phone number could not be verified. Please start again from the website.")
+			return
+		}
+		s.replyWhatsApp(phoneNumberID, sender, message)
+		return
+	}
+
+	for _, attempt := range attempts {
+		attempt, exists := verificationAttempts.update(attempt.Token, func(attempt *VerificationAttempt) {
+			attempt.Attempts++
+		})
+		if exists && attempt.Attempts >= attempt.MaxAttempts {
+			verificationAttempts.remove(attempt.Token)
+		}
+	}
+	s.replyWhatsApp(phoneNumberID, sender, "This code is not valid. Please check the code shown on the website.")
+}
+
+// findReverseVerificationAttempts returns the pending, not expired attempts whose code may be
+// sent from phoneNumber to the business number phoneNumberID: the number being verified, or the
+// current number while it has to be proven before a change. Login challenges are completed on
+// the website only.
+func findReverseVerificationAttempts(phoneNumberID, phoneNumber string) []*VerificationAttempt {
+	now := time.Now()
+	return verificationAttempts.find(func(attempt *VerificationAttempt) bool {
+		if attempt.Status != "pending" || attempt.isLoginChallenge() || now.After(attempt.ExpiresAt) ||
+			attempt.Attempts >= attempt.MaxAttempts || attempt.Method == VERIFICATION_METHOD_TELEGRAM {
+			return false
+		}
+		if phoneNumberID != "" && whatsAppConfig.ForInstance(attempt.InstanceID).PhoneNumberID != phoneNumberID {
+			return false
+		}
+		if attempt.AwaitingOldNumberProof {
+			return attempt.OldNumberProofMethod == PROOF_METHOD_PHONE && attempt.ReplacesPhoneNumber == phoneNumber
+		}
+		return attempt.PhoneNumber == phoneNumber
+	})
+}
+
+// extractCodeCandidates returns the digit sequences of text, so the code is found whether it
+// is sent alone or with the prefilled "InfluenzaNet <code>" text.
+func extractCodeCandidates(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+}
+
+func containsCode(candidates []string, code string) bool {
+	for _, candidate := range candidates {
+		if codeMatches(code, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// replyWhatsApp answers an inbound message from the business number phoneNumberID it was sent
+// to. Free-form replies are allowed as the user wrote to us in the last 24 hours, and need no
+// opt-in as they are not template messages.
+func (s *userManagementServer) replyWhatsApp(phoneNumberID, phoneNumber, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	instanceID := ""
+	if instanceIDs := whatsAppConfig.instancesForPhoneNumberID(phoneNumberID); len(instanceIDs) > 0 {
+		instanceID = instanceIDs[0]
+	}
+	if err := whatsAppClientFor(instanceID).SendText(ctx, phoneNumber, text); err != nil {
+		log.Printf("Error replying on WhatsApp: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/influenzanet/user-management-service/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWhatsAppReverseVerification(t *testing.T) {
+	t.Setenv(ENV_WHATSAPP_BUSINESS_PHONE_NUMBER, "+41445550000")
+
+	s := &userManagementServer{
+		userDBservice:   testUserDBService,
+		globalDBService: testGlobalDBService,
+	}
+
+	t.Run("code is shown and completed by the message from the phone", func(t *testing.T) {
+		contactID := primitive.NewObjectID()
+		userID, err := testUserDBService.AddUser(testInstanceID, models.User{
+			Account: models.Account{AccountID: "whatsapp-reverse@test.com"},
+			ContactInfos: []models.ContactInfo{
+				{ID: contactID, Type: "phone", Phone: "+41791230201"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		attempt, err := s.startPhoneVerification(&VerificationAttempt{
+			UserID:      userID,
+			InstanceID:  testInstanceID,
+			Purpose:     VERIFICATION_PURPOSE_VERIFY_PHONE,
+			PhoneNumber: "+41791230201",
+			ContactID:   contactID.Hex(),
+			Method:      VERIFICATION_METHOD_WHATSAPP_REVERSE,
+		}, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if attempt.Code != "" || attempt.ReverseCode == "" {
+			t.Fatalf("expected only a code to show, got code %q and reverse code %q", attempt.Code, attempt.ReverseCode)
+		}
+		instructions := attempt.reverseInstructions()
+		if instructions == nil || instructions.BusinessPhoneNumber != "+41445550000" || !strings.Contains(instructions.MessageText, attempt.ReverseCode) {
+			t.Fatalf("unexpected instructions: %v", instructions)
+		}
+
+		s.handleWhatsAppInboundText("", "41791230201", instructions.MessageText)
+
+		user, err := testUserDBService.GetUser(testInstanceID, userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		contact := findPhoneContact(user, contactID.Hex())
+		if contact == nil || contact.ConfirmedAt <= 0 || contact.VerifiedVia != VERIFICATION_METHOD_WHATSAPP_REVERSE {
+			t.Errorf("expected the number to be verified by the reverse message, got %v", contact)
+		}
+	})
+
+	t.Run("login codes are never shown", func(t *testing.T) {
+		_, err := s.startPhoneVerification(&VerificationAttempt{
+			InstanceID:  testInstanceID,
+			Purpose:     VERIFICATION_PURPOSE_LOGIN,
+			PhoneNumber: "+41791230202",
+			Method:      VERIFICATION_METHOD_WHATSAPP_REVERSE,
+		}, "")
+		if err == nil {
+			t.Error("expected the reverse method to be refused for login")
+		}
+	})
+}
+
+func TestExtractCodeCandidates(t *testing.T) {
+	tests := []struct {
+		text       string
+		candidates []string
+	}{
+		{"123456", []string{"123456"}},
+		{"InfluenzaNet 123456", []string{"123456"}},
+		{"  123456\n", []string{"123456"}},
+		{"code: 123-456", []string{"123", "456"}},
+		{"12 then 345678", []string{"12", "345678"}},
+		{"no code here", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			candidates := extractCodeCandidates(tt.text)
+			if strings.Join(candidates, ",") != strings.Join(tt.candidates, ",") {
+				t.Errorf("expected %q, got %q", tt.candidates, candidates)
+			}
+		})
+	}
+}
+
+func TestContainsCode(t *testing.T) {
+	tests := []struct {
+		text     string
+		code     string
+		contains bool
+	}{
+		{"InfluenzaNet 123456", "123456", true},
+		{"123456 InfluenzaNet", "123456", true},
+		{"1234567", "123456", false},
+		{"123 456", "123456", false},
+		{"InfluenzaNet", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if contains := containsCode(extractCodeCandidates(tt.text), tt.code); contains != tt.contains {
+				t.Errorf("expected %v, got %v", tt.contains, contains)
+			}
+		})
+	}
+}
+
+func TestValidWhatsAppSignature(t *testing.T) {
+	config := WhatsAppConfig{}
+	config.WhatsApp.AppSecret = "default-secret"
+	config.WhatsApp.Instances = map[string]InstanceWhatsAppConfig{
+		"other": {AppSecret: "other-secret"},
+	}
+	previous := whatsAppConfig
+	whatsAppConfig = config
+	defer func() { whatsAppConfig = previous }()
+
+	payload := []byte(`{"object":"whatsapp_business_account"}`)
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		return WHATSAPP_SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name      string
+		signature string
+		valid     bool
+	}{
+		{"default app", sign("default-secret"), true},
+		{"instance app", sign("other-secret"), true},
+		{"unknown secret", sign("wrong-secret"), false},
+		{"missing prefix", strings.TrimPrefix(sign("default-secret"), WHATSAPP_SIGNATURE_PREFIX), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := validWhatsAppSignature(payload, tt.signature); valid != tt.valid {
+				t.Errorf("expected %v, got %v", tt.valid, valid)
+			}
+		})
+	}
+}
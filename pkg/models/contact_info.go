@@ -0,0 +1,63 @@
+package models
+
+import (
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ContactInfo describes an email address or phone number of the user. Phone contacts
+// additionally record whether they are the main number, the channels they may be used on
+// and how and when they were last verified.
+type ContactInfo struct {
+	ID                     primitive.ObjectID `bson:"_id,omitempty"`
+	Type                   string             `bson:"type"`
+	ConfirmedAt            int64              `bson:"confirmedAt"`
+	ConfirmationLinkSentAt int64              `bson:"confirmationLinkSentAt"`
+	Email                  string             `bson:"email,omitempty"`
+	Phone                  string             `bson:"phone,omitempty"`
+	Primary                bool               `bson:"primary,omitempty"`
+	Channels               []string           `bson:"channels,omitempty"`       // "whatsapp", "sms", "voice"; empty allows all
+	VerifiedVia            string             `bson:"verifiedVia,omitempty"`    // channel of the last verification
+	LastVerifiedAt         int64              `bson:"lastVerifiedAt,omitempty"` // ConfirmedAt is only set by the first one
+}
+
+// ContactInfoFromAPI converts the object from API to DB format
+func ContactInfoFromAPI(obj *api.ContactInfo) ContactInfo {
+	if obj == nil {
+		return ContactInfo{}
+	}
+	_id, _ := primitive.ObjectIDFromHex(obj.Id)
+	return ContactInfo{
+		ID:                     _id,
+		Type:                   obj.Type,
+		ConfirmedAt:            obj.ConfirmedAt,
+		ConfirmationLinkSentAt: obj.ConfirmationLinkSentAt,
+		Email:                  obj.GetEmail(),
+		Phone:                  obj.GetPhone(),
+		Primary:                obj.Primary,
+		Channels:               obj.Channels,
+		VerifiedVia:            obj.VerifiedVia,
+		LastVerifiedAt:         obj.LastVerifiedAt,
+	}
+}
+
+// ToAPI converts the object from DB to API format
+func (ci ContactInfo) ToAPI() *api.ContactInfo {
+	contactInfo := &api.ContactInfo{
+		Id:                     ci.ID.Hex(),
+		Type:                   ci.Type,
+		ConfirmedAt:            ci.ConfirmedAt,
+		ConfirmationLinkSentAt: ci.ConfirmationLinkSentAt,
+		Primary:                ci.Primary,
+		Channels:               ci.Channels,
+		VerifiedVia:            ci.VerifiedVia,
+		LastVerifiedAt:         ci.LastVerifiedAt,
+	}
+	switch ci.Type {
+	case "email":
+		contactInfo.Address = &api.ContactInfo_Email{Email: ci.Email}
+	case "phone":
+		contactInfo.Address = &api.ContactInfo_Phone{Phone: ci.Phone}
+	}
+	return contactInfo
+}
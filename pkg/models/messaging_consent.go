@@ -0,0 +1,19 @@
+package models
+
+// MessagingConsent documents the opt-in of a user to receive messages on a channel at a phone
+// number. Records are kept after revocation: granting again adds a new one.
+type MessagingConsent struct {
+	Channel       string `bson:"channel" json:"channel"`
+	PhoneNumber   string `bson:"phoneNumber" json:"phoneNumber"`
+	GrantedAt     int64  `bson:"grantedAt" json:"grantedAt"`
+	Source        string `bson:"source" json:"source"`
+	PolicyVersion string `bson:"policyVersion" json:"policyVersion"`
+	IPAddress     string `bson:"ipAddress" json:"-"`
+	RevokedAt     int64  `bson:"revokedAt" json:"revokedAt"`
+	RevokedSource string `bson:"revokedSource,omitempty" json:"revokedSource,omitempty"`
+}
+
+// Active reports whether the consent has not been revoked.
+func (c MessagingConsent) Active() bool {
+	return c.RevokedAt == 0
+}
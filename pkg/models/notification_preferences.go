@@ -0,0 +1,13 @@
+package models
+
+// NotificationPreferences holds the channel ("email", "whatsapp") a user wants to receive
+// each type of message on, keyed by message type. Types without an entry use the default.
+type NotificationPreferences struct {
+	Channels  map[string]string `bson:"channels" json:"channels"`
+	UpdatedAt int64             `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Channel returns the channel chosen for messageType, or "" if none was chosen.
+func (p NotificationPreferences) Channel(messageType string) string {
+	return p.Channels[messageType]
+}
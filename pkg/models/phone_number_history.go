@@ -0,0 +1,10 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// PhoneNumberHistoryEntry records a phone number that was replaced on a user's contact infos.
+type PhoneNumberHistoryEntry struct {
+	ContactID  primitive.ObjectID `bson:"contactId" json:"contactId"`
+	Phone      string             `bson:"phone" json:"phone"`
+	ReplacedAt int64              `bson:"replacedAt" json:"replacedAt"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// PhoneSecondFactor is the login second factor of a user: a code sent to one of their confirmed
+// phone contacts, or one of the single-use recovery codes.
+type PhoneSecondFactor struct {
+	Enabled       bool               `bson:"enabled" json:"enabled"`
+	ContactID     primitive.ObjectID `bson:"contactId" json:"contactId"`
+	Method        string             `bson:"method" json:"method"`
+	EnabledAt     int64              `bson:"enabledAt" json:"enabledAt"`
+	RecoveryCodes []RecoveryCode     `bson:"recoveryCodes" json:"-"`
+}
+
+// RecoveryCode is a hashed single-use code replacing the phone code when the phone is unavailable.
+type RecoveryCode struct {
+	Hash   string `bson:"hash" json:"-"`
+	UsedAt int64  `bson:"usedAt" json:"usedAt"`
+}
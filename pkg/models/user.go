@@ -0,0 +1,28 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// User describes the user as saved in the DB
+type User struct {
+	ID                      primitive.ObjectID        `bson:"_id,omitempty"`
+	Account                 Account                   `bson:"account"`
+	Roles                   []string                  `bson:"roles" json:"roles"`
+	Timestamps              Timestamps                `bson:"timestamps"`
+	Profiles                []Profile                 `bson:"profiles"`
+	ContactPreferences      ContactPreferences        `bson:"contactPreferences"`
+	ContactInfos            []ContactInfo             `bson:"contactInfos"`
+	PhoneNumberHistory      []PhoneNumberHistoryEntry `bson:"phoneNumberHistory,omitempty"` // numbers replaced by a phone change, oldest first
+	PhoneSecondFactor       PhoneSecondFactor         `bson:"phoneSecondFactor,omitempty"`
+	NotificationPreferences NotificationPreferences   `bson:"notificationPreferences,omitempty"`
+	MessagingConsents       []MessagingConsent        `bson:"messagingConsents,omitempty"`
+}
+
+// HasRole checks whether the user has a specified role
+func (u User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package phone
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:embed metadata.json
+var metadataJSON []byte
+
+// typeRule describes the national numbers of one number type in a region.
+type typeRule struct {
+	Prefixes []string `json:"prefixes"`
+	Lengths  []int    `json:"lengths"`
+}
+
+// regionMetadata holds the numbering plan rules for a region, keyed by ISO 3166-1 alpha-2 code.
+// Regions sharing a country code with a main region list the leading digits (area codes) of
+// their national numbers.
+type regionMetadata struct {
+	CountryCode         string              `json:"countryCode"`
+	InternationalPrefix string              `json:"internationalPrefix"`
+	NationalPrefix      string              `json:"nationalPrefix"`
+	AreaCodes           []string            `json:"areaCodes"` // set for regions sharing their country code, e.g. CA in the NANP
+	Types               map[string]typeRule `json:"types"`
+}
+
+// metadata keys used in metadata.json
+var typeKeys = map[string]NumberType{
+	"mobile":            TypeMobile,
+	"fixedLine":         TypeFixedLine,
+	"fixedLineOrMobile": TypeFixedLineOrMobile,
+	"voip":              TypeVoIP,
+	"tollFree":          TypeTollFree,
+	"premiumRate":       TypePremiumRate,
+}
+
+var (
+	regions            map[string]regionMetadata
+	regionsCountryCode map[string]string
+	// Regions with area codes, by the country code they share with the main region
+	sharedRegions map[string][]string
+)
+
+func init() {
+	if err := json.Unmarshal(metadataJSON, &regions); err != nil {
+		panic(fmt.Sprintf("phone: invalid embedded metadata: %v", err))
+	}
+
+	regionsCountryCode = make(map[string]string, len(regions))
+	sharedRegions = make(map[string][]string)
+	for region, md := range regions {
+		if md.InternationalPrefix == "" {
+			md.InternationalPrefix = "00"
+			regions[region] = md
+		}
+		for key := range md.Types {
+			if _, ok := typeKeys[key]; !ok {
+				panic(fmt.Sprintf("phone: unknown number type %q in metadata for %s", key, region))
+			}
+		}
+		if len(md.AreaCodes) > 0 {
+			sharedRegions[md.CountryCode] = append(sharedRegions[md.CountryCode], region)
+			continue
+		}
+		regionsCountryCode[md.CountryCode] = region
+	}
+	for countryCode, shared := range sharedRegions {
+		if _, ok := regionsCountryCode[countryCode]; !ok {
+			panic(fmt.Sprintf("phone: no main region for country code %s in metadata", countryCode))
+		}
+		sort.Strings(shared)
+	}
+}
+
+// regionOf returns the region a national number of countryCode belongs to: the region whose
+// area codes it starts with, for country codes shared by several regions, or else the main
+// region of the country code.
+func regionOf(countryCode, nationalNumber string) string {
+	for _, region := range sharedRegions[countryCode] {
+		for _, areaCode := range regions[region].AreaCodes {
+			if strings.HasPrefix(nationalNumber, areaCode) {
+				return region
+			}
+		}
+	}
+	return regionsCountryCode[countryCode]
+}
+
+// classify returns the type of a national significant number, picking the rule with the longest
+// matching prefix whose length constraint is satisfied.
+func (md regionMetadata) classify(nationalNumber string) (NumberType, bool) {
+	bestType := TypeUnknown
+	bestPrefixLen := -1
+	for key, rule := range md.Types {
+		if !containsInt(rule.Lengths, len(nationalNumber)) {
+			continue
+		}
+		for _, prefix := range rule.Prefixes {
+			if len(prefix) > bestPrefixLen && len(nationalNumber) >= len(prefix) && nationalNumber[:len(prefix)] == prefix {
+				bestType = typeKeys[key]
+				bestPrefixLen = len(prefix)
+			}
+		}
+	}
+	return bestType, bestPrefixLen >= 0
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+// Package phone parses, validates and normalizes phone numbers to E.164, using per-region
+// numbering plan rules embedded in metadata.json. The metadata covers BE, CA, CH, DE, ES, FR, GB,
+// IT, NL, PT and US; numbers of other country codes are accepted in international format with
+// TypeUnknown and no length check beyond E.164. NANP numbers (US, CA) cannot be told apart by
+// their digits and are TypeFixedLineOrMobile.
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+type NumberType string
+
+const (
+	TypeUnknown           NumberType = "unknown"
+	TypeMobile            NumberType = "mobile"
+	TypeFixedLine         NumberType = "fixed-line"
+	TypeFixedLineOrMobile NumberType = "fixed-line-or-mobile"
+	TypeVoIP              NumberType = "voip"
+	TypeTollFree          NumberType = "toll-free"
+	TypePremiumRate       NumberType = "premium-rate"
+)
+
+const (
+	maxE164Digits     = 15
+	minNationalDigits = 4
+)
+
+var (
+	ErrEmpty             = errors.New("phone number is empty")
+	ErrInvalidCharacters = errors.New("phone number contains invalid characters")
+	ErrMissingRegion     = errors.New("phone number is in national format but no default region is set")
+	ErrUnknownRegion     = errors.New("unknown region")
+	ErrInvalidLength     = errors.New("phone number has an invalid length")
+	ErrInvalidNumber     = errors.New("phone number is not valid for its region")
+)
+
+// Number is a parsed phone number.
+type Number struct {
+	CountryCode string
+	// Region is the ISO 3166-1 alpha-2 code, empty if the country code has no metadata.
+	Region string
+	// NationalNumber is the national significant number, without national prefix.
+	NationalNumber string
+	Type           NumberType
+}
+
+// E164 returns the canonical "+<country code><national number>" form.
+func (n Number) E164() string {
+	return "+" + n.CountryCode + n.NationalNumber
+}
+
+// Parse parses raw in international ("+39 ...", "0039 ...") or national format. National numbers
+// are interpreted in defaultRegion. Numbers of regions with metadata are validated against
+// their length and prefix rules and classified; other country codes are only checked for the
+// E.164 length.
+func Parse(raw, defaultRegion string) (Number, error) {
+	digits, international, err := stripFormatting(raw)
+	if err != nil {
+		return Number{}, err
+	}
+
+	var defaultMd *regionMetadata
+	if defaultRegion != "" {
+		md, ok := regions[strings.ToUpper(defaultRegion)]
+		if !ok {
+			return Number{}, ErrUnknownRegion
+		}
+		defaultMd = &md
+	}
+
+	if !international {
+		switch {
+		case strings.HasPrefix(digits, "00"):
+			digits, international = digits[2:], true
+		case defaultMd != nil && strings.HasPrefix(digits, defaultMd.InternationalPrefix):
+			digits, international = digits[len(defaultMd.InternationalPrefix):], true
+		}
+	}
+
+	if international {
+		return parseInternational(digits)
+	}
+	if defaultMd == nil {
+		return Number{}, ErrMissingRegion
+	}
+	return parseNational(digits, strings.ToUpper(defaultRegion), *defaultMd)
+}
+
+// Normalize returns the E.164 form of raw, see Parse.
+func Normalize(raw, defaultRegion string) (string, error) {
+	n, err := Parse(raw, defaultRegion)
+	if err != nil {
+		return "", err
+	}
+	return n.E164(), nil
+}
+
+// stripFormatting removes separators commonly used when writing numbers and reports whether
+// the number starts with "+".
+func stripFormatting(raw string) (string, bool, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", false, ErrEmpty
+	}
+
+	international := strings.HasPrefix(raw, "+")
+	if international {
+		raw = raw[1:]
+	}
+
+	var b strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/' || r == '\u00a0':
+			continue
+		default:
+			return "", false, ErrInvalidCharacters
+		}
+	}
+	if b.Len() == 0 {
+		return "", false, ErrEmpty
+	}
+	return b.String(), international, nil
+}
+
+func parseInternational(digits string) (Number, error) {
+	if len(digits) > maxE164Digits || strings.HasPrefix(digits, "0") {
+		return Number{}, ErrInvalidLength
+	}
+
+	for ccLen := 1; ccLen <= 3 && ccLen < len(digits); ccLen++ {
+		region, ok := regionsCountryCode[digits[:ccLen]]
+		if !ok {
+			continue
+		}
+		md := regions[region]
+		nationalNumber := digits[ccLen:]
+		// Accept the national prefix written after the country code, e.g. "+33 (0)6 ..."
+		if md.NationalPrefix != "" && strings.HasPrefix(nationalNumber, md.NationalPrefix) {
+			if n, err := parseNational(nationalNumber, region, md); err == nil {
+				return n, nil
+			}
+		}
+		return validate(nationalNumber, region, md)
+	}
+
+	// No metadata for this country code: only the overall E.164 shape can be checked
+	for ccLen := 1; ccLen <= 3 && ccLen < len(digits); ccLen++ {
+		if !countryCodes[digits[:ccLen]] {
+			continue
+		}
+		if len(digits)-ccLen < minNationalDigits {
+			return Number{}, ErrInvalidLength
+		}
+		return Number{
+			CountryCode:    digits[:ccLen],
+			NationalNumber: digits[ccLen:],
+			Type:           TypeUnknown,
+		}, nil
+	}
+	return Number{}, ErrInvalidNumber
+}
+
+func parseNational(digits, region string, md regionMetadata) (Number, error) {
+	if md.NationalPrefix != "" && strings.HasPrefix(digits, md.NationalPrefix) {
+		digits = digits[len(md.NationalPrefix):]
+	}
+	return validate(digits, region, md)
+}
+
+func validate(nationalNumber, region string, md regionMetadata) (Number, error) {
+	if len(nationalNumber) < minNationalDigits || len(md.CountryCode)+len(nationalNumber) > maxE164Digits {
+		return Number{}, ErrInvalidLength
+	}
+	// The number decides the region among those sharing a country code, not the default region
+	if len(sharedRegions[md.CountryCode]) > 0 {
+		region = regionOf(md.CountryCode, nationalNumber)
+		md = regions[region]
+	}
+	numberType, ok := md.classify(nationalNumber)
+	if !ok {
+		return Number{}, ErrInvalidNumber
+	}
+	return Number{
+		CountryCode:    md.CountryCode,
+		Region:         region,
+		NationalNumber: nationalNumber,
+		Type:           numberType,
+	}, nil
+}
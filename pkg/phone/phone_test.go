@@ -0,0 +1,73 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		defaultRegion string
+		e164          string
+		region        string
+		numberType    NumberType
+	}{
+		{"international mobile", "+41 79 123 45 67", "", "+41791234567", "CH", TypeMobile},
+		{"international with 00", "0041 79 123 45 67", "", "+41791234567", "CH", TypeMobile},
+		{"national with prefix", "079 123 45 67", "CH", "+41791234567", "CH", TypeMobile},
+		{"national prefix after country code", "+33 (0)6 12 34 56 78", "", "+33612345678", "FR", TypeMobile},
+		{"no national prefix", "347 123 4567", "it", "+393471234567", "IT", TypeMobile},
+		{"fixed line keeps the leading zero", "+39 06 1234 5678", "", "+390612345678", "IT", TypeFixedLine},
+		{"toll free", "+41 800 123 456", "", "+41800123456", "CH", TypeTollFree},
+		{"country code without metadata", "+30 691 234 5678", "", "+306912345678", "", TypeUnknown},
+		{"nanp main region", "+1 212 555 0100", "", "+12125550100", "US", TypeFixedLineOrMobile},
+		{"nanp area code", "+1 416 555 0100", "", "+14165550100", "CA", TypeFixedLineOrMobile},
+		{"nanp national area code", "(416) 555-0100", "US", "+14165550100", "CA", TypeFixedLineOrMobile},
+		{"nanp national prefix", "1 604 555 0100", "US", "+16045550100", "CA", TypeFixedLineOrMobile},
+		{"nanp default region ignored", "212 555 0100", "CA", "+12125550100", "US", TypeFixedLineOrMobile},
+		{"nanp international prefix", "011 41 79 123 45 67", "US", "+41791234567", "CH", TypeMobile},
+		{"nanp toll free", "+1 800 555 0100", "", "+18005550100", "US", TypeTollFree},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Parse(tt.raw, tt.defaultRegion)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n.E164() != tt.e164 || n.Region != tt.region || n.Type != tt.numberType {
+				t.Errorf("expected %s in %q (%s), got %s in %q (%s)", tt.e164, tt.region, tt.numberType, n.E164(), n.Region, n.Type)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		defaultRegion string
+		err           error
+	}{
+		{"empty", "  ", "", ErrEmpty},
+		{"only separators", "+ ( ) -", "", ErrEmpty},
+		{"letters", "+41 79 ABC 45 67", "", ErrInvalidCharacters},
+		{"national without region", "079 123 45 67", "", ErrMissingRegion},
+		{"unknown region", "079 123 45 67", "XX", ErrUnknownRegion},
+		{"too short", "+41 12", "", ErrInvalidLength},
+		{"too long", "+41 79 123 45 67 89 01 23", "", ErrInvalidLength},
+		{"wrong length for region", "+41 79 123 45", "", ErrInvalidNumber},
+		{"unassigned country code", "+999 1234 5678", "", ErrInvalidNumber},
+		{"nanp short", "+1 416 555 010", "", ErrInvalidNumber},
+		{"nanp invalid leading digit", "+1 016 555 0100", "", ErrInvalidNumber},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.raw, tt.defaultRegion)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package reminders is the message scheduler side of the WhatsApp survey reminders: it calls
+// SendWhatsAppReminders of the user management service batch by batch, and reports the users
+// who have to be emailed instead.
+package reminders
+
+import (
+	"context"
+	"time"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"google.golang.org/grpc"
+)
+
+const (
+	REMINDER_TYPE_WEEKLY = "weekly-reminder"
+
+	// Users per call, the most SendWhatsAppReminders accepts
+	BATCH_SIZE = 200
+	// Deadline of a single call
+	CALL_TIMEOUT = 2 * time.Minute
+)
+
+// Sender is the part of the user management client the scheduler needs.
+type Sender interface {
+	SendWhatsAppReminders(ctx context.Context, in *api.SendWhatsAppRemindersRequest, opts ...grpc.CallOption) (*api.SendWhatsAppRemindersResponse, error)
+}
+
+// Result lists the users of a run by outcome. Skipped users cannot be reached on WhatsApp and
+// should get the email version of the message; failed users may be retried.
+type Result struct {
+	Sent    []string
+	Skipped []string
+	Failed  []string
+}
+
+func (r *Result) add(resp *api.SendWhatsAppRemindersResponse) {
+	r.Sent = append(r.Sent, resp.SentUserIds...)
+	r.Skipped = append(r.Skipped, resp.SkippedUserIds...)
+	r.Failed = append(r.Failed, resp.FailedUserIds...)
+}
+
+// SendWeekly sends today's weekly reminders of instanceID over WhatsApp, page by page. On error
+// the result holds the pages sent so far.
+func SendWeekly(ctx context.Context, client Sender, instanceID string) (Result, error) {
+	result := Result{}
+	pageToken := ""
+	for {
+		resp, err := send(ctx, client, &api.SendWhatsAppRemindersRequest{
+			InstanceId:  instanceID,
+			MessageType: REMINDER_TYPE_WEEKLY,
+			PageToken:   pageToken,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.add(resp)
+		if resp.NextPageToken == "" {
+			return result, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// SendToUsers sends messageType, e.g. a study invitation, to userIDs over WhatsApp in batches of
+// BATCH_SIZE. On error the result holds the batches sent so far.
+func SendToUsers(ctx context.Context, client Sender, instanceID, messageType, studyKey, surveyKey string, userIDs []string) (Result, error) {
+	result := Result{}
+	for start := 0; start < len(userIDs); start += BATCH_SIZE {
+		end := start + BATCH_SIZE
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		resp, err := send(ctx, client, &api.SendWhatsAppRemindersRequest{
+			InstanceId:  instanceID,
+			MessageType: messageType,
+			UserIds:     userIDs[start:end],
+			StudyKey:    studyKey,
+			SurveyKey:   surveyKey,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.add(resp)
+	}
+	return result, nil
+}
+
+func send(ctx context.Context, client Sender, req *api.SendWhatsAppRemindersRequest) (*api.SendWhatsAppRemindersResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, CALL_TIMEOUT)
+	defer cancel()
+	return client.SendWhatsAppReminders(ctx, req)
+}
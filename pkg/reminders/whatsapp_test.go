@@ -0,0 +1,81 @@
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/influenzanet/user-management-service/pkg/api"
+	"google.golang.org/grpc"
+)
+
+// fakeSender answers weekly reminder calls with pages of one user, and sends to every user ID.
+type fakeSender struct {
+	pages    int
+	requests []*api.SendWhatsAppRemindersRequest
+}
+
+func (f *fakeSender) SendWhatsAppReminders(ctx context.Context, in *api.SendWhatsAppRemindersRequest, opts ...grpc.CallOption) (*api.SendWhatsAppRemindersResponse, error) {
+	f.requests = append(f.requests, in)
+	if len(in.UserIds) > BATCH_SIZE {
+		return nil, fmt.Errorf("too many user ids: %d", len(in.UserIds))
+	}
+	if len(in.UserIds) > 0 {
+		return &api.SendWhatsAppRemindersResponse{SentUserIds: in.UserIds}, nil
+	}
+
+	page := len(f.requests)
+	resp := &api.SendWhatsAppRemindersResponse{SkippedUserIds: []string{fmt.Sprintf("user-%d", page)}}
+	if page < f.pages {
+		resp.NextPageToken = fmt.Sprintf("page-%d", page)
+	}
+	return resp, nil
+}
+
+func TestSendWeekly(t *testing.T) {
+	for _, pages := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d pages", pages), func(t *testing.T) {
+			sender := &fakeSender{pages: pages}
+			result, err := SendWeekly(context.Background(), sender, "test")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(sender.requests) != pages || len(result.Skipped) != pages {
+				t.Errorf("expected %d pages, got %d calls and %v", pages, len(sender.requests), result)
+			}
+			for i, req := range sender.requests {
+				if expected := fmt.Sprintf("page-%d", i); i > 0 && req.PageToken != expected {
+					t.Errorf("expected page token %s, got %s", expected, req.PageToken)
+				}
+			}
+		})
+	}
+}
+
+func TestSendToUsers(t *testing.T) {
+	tests := []struct {
+		users int
+		calls int
+	}{
+		{users: 0, calls: 0},
+		{users: 1, calls: 1},
+		{users: BATCH_SIZE, calls: 1},
+		{users: BATCH_SIZE + 1, calls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d users", tt.users), func(t *testing.T) {
+			userIDs := make([]string, tt.users)
+			for i := range userIDs {
+				userIDs[i] = fmt.Sprintf("user-%d", i)
+			}
+			sender := &fakeSender{}
+			result, err := SendToUsers(context.Background(), sender, "test", "study-invitation", "study", "survey", userIDs)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(sender.requests) != tt.calls || len(result.Sent) != tt.users {
+				t.Errorf("expected %d calls sending to %d users, got %d calls and %d sent", tt.calls, tt.users, len(sender.requests), len(result.Sent))
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeVaultServer is an http.Handler serving KV v2 reads and writes like Vault does, keeping
+// secrets in memory. Point NewVault at it, e.g. with httptest.NewServer, and call Put to
+// rotate a secret.
+type FakeVaultServer struct {
+	Token string
+
+	mu       sync.Mutex
+	secrets  map[string]map[string]string // "<mount>/<path>" -> fields
+	versions map[string]int
+}
+
+func NewFakeVaultServer(token string) *FakeVaultServer {
+	return &FakeVaultServer{
+		Token:    token,
+		secrets:  map[string]map[string]string{},
+		versions: map[string]int{},
+	}
+}
+
+// ServeHTTP handles GET, POST and PUT "/v1/<mount>/data/<path>" requests.
+func (f *FakeVaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Header.Get("X-Vault-Token") != f.Token {
+		writeFakeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/"), "/data/", 2)
+	if !strings.HasPrefix(r.URL.Path, "/v1/") || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeFakeVaultError(w, http.StatusNotFound, "")
+		return
+	}
+	secretPath := parts[0] + "/" + parts[1]
+
+	switch r.Method {
+	case "GET":
+		fields, version, ok := f.get(secretPath)
+		if !ok {
+			writeFakeVaultError(w, http.StatusNotFound, "")
+			return
+		}
+		var response kvResponse
+		response.Data.Data = fields
+		response.Data.Metadata.Version = version
+		json.NewEncoder(w).Encode(response)
+	case "POST", "PUT":
+		var body struct {
+			Data map[string]string `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Data == nil {
+			writeFakeVaultError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+		version := f.Put(secretPath, body.Data)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]int{"version": version},
+		})
+	default:
+		writeFakeVaultError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+// Put stores a new version of the secret at secretPath ("<mount>/<path>") and returns its
+// version number.
+func (f *FakeVaultServer) Put(secretPath string, fields map[string]string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stored := make(map[string]string, len(fields))
+	for name, value := range fields {
+		stored[name] = value
+	}
+	secretPath = strings.Trim(secretPath, "/")
+	f.secrets[secretPath] = stored
+	f.versions[secretPath]++
+	return f.versions[secretPath]
+}
+
+func (f *FakeVaultServer) get(secretPath string) (map[string]string, int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fields, ok := f.secrets[secretPath]
+	if !ok {
+		return nil, 0, false
+	}
+	copied := make(map[string]string, len(fields))
+	for name, value := range fields {
+		copied[name] = value
+	}
+	return copied, f.versions[secretPath], true
+}
+
+func writeFakeVaultError(w http.ResponseWriter, statusCode int, message string) {
+	w.WriteHeader(statusCode)
+	errors := []string{}
+	if message != "" {
+		errors = append(errors, message)
+	}
+	json.NewEncoder(w).Encode(map[string][]string{"errors": errors})
+}
@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Rotating holds the current value of a secret, reloaded with Refresh so a rotated secret is
+// used without restarting the service.
+type Rotating struct {
+	provider Provider
+	names    []string
+
+	mu    sync.RWMutex
+	value string
+}
+
+// NewRotating loads the first of names the provider has; later names are fallbacks, e.g. a
+// shared default for a per-tenant secret.
+func NewRotating(ctx context.Context, provider Provider, names ...string) *Rotating {
+	r := &Rotating{provider: provider, names: names}
+	if err := r.Refresh(ctx); err != nil {
+		log.Printf("Failed to load secret %v: %v", names, err)
+	}
+	return r
+}
+
+// Fixed returns a secret that never changes, e.g. a value set in a config file.
+func Fixed(value string) *Rotating {
+	return &Rotating{value: value}
+}
+
+// Value returns the current value, "" if the secret could not be loaded.
+func (r *Rotating) Value() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.value
+}
+
+// Refresh reloads the secret. The previous value is kept if the provider cannot be read, and
+// cleared only if the secret was removed.
+func (r *Rotating) Refresh(ctx context.Context) error {
+	if r.provider == nil {
+		return nil
+	}
+
+	name, value, err := r.lookup(ctx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	r.mu.Lock()
+	if value != r.value && r.value != "" {
+		log.Printf("Secret %s rotated", name)
+	}
+	r.value = value
+	r.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the secret every interval, until ctx is done.
+func (r *Rotating) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			if err := r.Refresh(refreshCtx); err != nil {
+				log.Printf("Failed to refresh secret %s: %v", r.names[0], err)
+			}
+			cancel()
+		}
+	}
+}
+
+// lookup returns the first of the names the provider has, with its value.
+func (r *Rotating) lookup(ctx context.Context) (string, string, error) {
+	for _, name := range r.names {
+		value, err := r.provider.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return name, value, err
+	}
+	return "", "", ErrNotFound
+}
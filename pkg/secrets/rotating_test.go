@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeProvider serves values, or err for every secret if set.
+type fakeProvider struct {
+	values map[string]string
+	err    error
+}
+
+func (f *fakeProvider) Get(ctx context.Context, name string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	value, ok := f.values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func TestRotating(t *testing.T) {
+	errUnavailable := errors.New("provider unavailable")
+	names := []string{"WHATSAPP_API_TOKEN_IT", "WHATSAPP_API_TOKEN"}
+
+	tests := []struct {
+		name    string
+		initial map[string]string
+		update  func(p *fakeProvider)
+		value   string
+		err     error
+	}{
+		{
+			name:    "first name wins",
+			initial: map[string]string{"WHATSAPP_API_TOKEN_IT": "it", "WHATSAPP_API_TOKEN": "default"},
+			update:  func(p *fakeProvider) {},
+			value:   "it",
+		},
+		{
+			name:    "falls back to later names",
+			initial: map[string]string{"WHATSAPP_API_TOKEN": "default"},
+			update:  func(p *fakeProvider) {},
+			value:   "default",
+		},
+		{
+			name:    "rotated value is used",
+			initial: map[string]string{"WHATSAPP_API_TOKEN": "old"},
+			update:  func(p *fakeProvider) { p.values["WHATSAPP_API_TOKEN"] = "new" },
+			value:   "new",
+		},
+		{
+			name:    "previous value kept if the provider fails",
+			initial: map[string]string{"WHATSAPP_API_TOKEN": "old"},
+			update:  func(p *fakeProvider) { p.err = errUnavailable },
+			value:   "old",
+			err:     errUnavailable,
+		},
+		{
+			name:    "cleared if the secret is removed",
+			initial: map[string]string{"WHATSAPP_API_TOKEN": "old"},
+			update:  func(p *fakeProvider) { delete(p.values, "WHATSAPP_API_TOKEN") },
+			value:   "",
+		},
+		{
+			name:    "loaded once available",
+			initial: map[string]string{},
+			update:  func(p *fakeProvider) { p.values["WHATSAPP_API_TOKEN_IT"] = "it" },
+			value:   "it",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{values: tt.initial}
+			secret := NewRotating(context.Background(), provider, names...)
+
+			tt.update(provider)
+			if err := secret.Refresh(context.Background()); !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+			if value := secret.Value(); value != tt.value {
+				t.Errorf("expected %q, got %q", tt.value, value)
+			}
+		})
+	}
+}
+
+func TestFixed(t *testing.T) {
+	secret := Fixed("from-config")
+	if err := secret.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value := secret.Value(); value != "from-config" {
+		t.Errorf("expected the fixed value, got %q", value)
+	}
+}
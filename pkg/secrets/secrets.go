@@ -0,0 +1,97 @@
+// Package secrets reads credentials from the environment, from files mounted by Docker or
+// Kubernetes, or from a Vault-compatible KV store, with a fake Vault server to run against
+// locally.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ENV_SECRETS_PROVIDER = "SECRETS_PROVIDER" // "env" (default), "file" or "vault"
+	ENV_SECRETS_DIR      = "SECRETS_DIR"      // directory of file secrets, default /run/secrets
+	ENV_VAULT_ADDR       = "VAULT_ADDR"
+	ENV_VAULT_TOKEN      = "VAULT_TOKEN"
+	// KV v2 mount and path of the secrets, e.g. "secret/user-management"
+	ENV_VAULT_SECRET_PATH = "VAULT_SECRET_PATH"
+)
+
+const DefaultSecretsDir = "/run/secrets"
+
+// ErrNotFound is returned by providers that have no secret of the requested name.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks up secrets by name, e.g. "WHATSAPP_API_TOKEN".
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// Env reads secrets from environment variables of the same name.
+type Env struct{}
+
+func (Env) Get(ctx context.Context, name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// File reads secrets from files named after them in Dir, as mounted by Docker secrets or
+// Kubernetes secret volumes. A trailing newline is removed.
+type File struct {
+	Dir string
+}
+
+func (f File) Get(ctx context.Context, name string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(f.Dir, filepath.Base(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Chain tries its providers in order, returning the first secret found.
+type Chain []Provider
+
+func (c Chain) Get(ctx context.Context, name string) (string, error) {
+	for _, provider := range c {
+		value, err := provider.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return value, err
+	}
+	return "", ErrNotFound
+}
+
+// FromEnv returns the provider selected by SECRETS_PROVIDER, falling back to environment
+// variables for secrets it does not have.
+func FromEnv() (Provider, error) {
+	switch kind := os.Getenv(ENV_SECRETS_PROVIDER); kind {
+	case "", "env":
+		return Env{}, nil
+	case "file":
+		dir := os.Getenv(ENV_SECRETS_DIR)
+		if dir == "" {
+			dir = DefaultSecretsDir
+		}
+		return Chain{File{Dir: dir}, Env{}}, nil
+	case "vault":
+		vault, err := NewVault(os.Getenv(ENV_VAULT_ADDR), os.Getenv(ENV_VAULT_TOKEN), os.Getenv(ENV_VAULT_SECRET_PATH))
+		if err != nil {
+			return nil, err
+		}
+		return Chain{vault, Env{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown %s %q", ENV_SECRETS_PROVIDER, kind)
+	}
+}
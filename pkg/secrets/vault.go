@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets from the fields of one KV v2 secret of a Vault-compatible server.
+type Vault struct {
+	addr       string
+	token      string
+	mount      string
+	path       string
+	httpClient *http.Client
+}
+
+// kvResponse is the body of a KV v2 read.
+type kvResponse struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// NewVault returns a provider for the KV v2 secret at secretPath ("<mount>/<path>") of the
+// server at addr.
+func NewVault(addr, token, secretPath string) (*Vault, error) {
+	parts := strings.SplitN(strings.Trim(secretPath, "/"), "/", 2)
+	if addr == "" || token == "" || len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("vault address, token and secret path (<mount>/<path>) required")
+	}
+	return &Vault{
+		addr:  strings.TrimSuffix(addr, "/"),
+		token: token,
+		mount: parts[0],
+		path:  parts[1],
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Get reads the latest version of the secret and returns its field name.
+func (v *Vault) Get(ctx context.Context, name string) (string, error) {
+	url := fmt.Sprintf("%s/v1/%s/data/%s", v.addr, v.mount, v.path)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault secret: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+
+	var kv kvResponse
+	if err := json.Unmarshal(body, &kv); err != nil {
+		return "", fmt.Errorf("vault error: status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault error: status %d: %s", resp.StatusCode, strings.Join(kv.Errors, ", "))
+	}
+
+	value, ok := kv.Data.Data[name]
+	if !ok || value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
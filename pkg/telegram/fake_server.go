@@ -0,0 +1,79 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeCall is a Bot API method call received by FakeServer.
+type FakeCall struct {
+	Token   string
+	Method  string
+	Payload map[string]interface{}
+}
+
+// FakeServer is an http.Handler answering Bot API calls like Telegram does, recording them
+// instead of delivering messages. Point the client at it, e.g. with httptest.NewServer.
+type FakeServer struct {
+	mu    sync.Mutex
+	calls []FakeCall
+}
+
+func NewFakeServer() *FakeServer {
+	return &FakeServer{}
+}
+
+// ServeHTTP handles "/bot<token>/<method>" requests.
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") || len(parts[0]) == len("bot") {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(apiResponse{OK: false, Description: "Not Found"})
+		return
+	}
+
+	var payload map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(apiResponse{OK: false, Description: "Bad Request: invalid JSON"})
+		return
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, FakeCall{
+		Token:   strings.TrimPrefix(parts[0], "bot"),
+		Method:  parts[1],
+		Payload: payload,
+	})
+	f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(apiResponse{OK: true, Result: json.RawMessage("true")})
+}
+
+// Calls returns the calls received so far.
+func (f *FakeServer) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeCall{}, f.calls...)
+}
+
+// Messages returns the texts sent to chatID with sendMessage.
+func (f *FakeServer) Messages(chatID int64) []string {
+	messages := []string{}
+	for _, call := range f.Calls() {
+		if call.Method != "sendMessage" {
+			continue
+		}
+		if id, ok := call.Payload["chat_id"].(float64); ok && int64(id) == chatID {
+			text, _ := call.Payload["text"].(string)
+			messages = append(messages, text)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,54 @@
+package tokens
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	signingKeyMu sync.RWMutex
+	signingKey   []byte
+)
+
+// SetSigningKey sets the base64 encoded key tokens are signed and validated with, so a key read
+// from a secret file or Vault does not have to be exported to the environment.
+func SetSigningKey(key string) error {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return err
+	}
+	if len(decoded) < 32 {
+		return errors.New("signing key must be at least 32 bytes")
+	}
+
+	signingKeyMu.Lock()
+	defer signingKeyMu.Unlock()
+	signingKey = decoded
+	return nil
+}
+
+// configuredSigningKey returns the key set with SetSigningKey, if any.
+func configuredSigningKey() ([]byte, bool) {
+	signingKeyMu.RLock()
+	defer signingKeyMu.RUnlock()
+	return signingKey, signingKey != nil
+}
+
+// getSecretKey returns the key tokens are signed and validated with: the key set with
+// SetSigningKey, or else the base64 encoded JWT_TOKEN_KEY environment variable.
+func getSecretKey() ([]byte, error) {
+	if key, ok := configuredSigningKey(); ok {
+		return key, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("JWT_TOKEN_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 32 {
+		return nil, errors.New("couldn't find proper secret key")
+	}
+	return key, nil
+}
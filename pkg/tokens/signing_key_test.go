@@ -0,0 +1,47 @@
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestGetSecretKey(t *testing.T) {
+	envKey := bytes.Repeat([]byte("e"), 32)
+	configuredKey := bytes.Repeat([]byte("c"), 32)
+	t.Setenv("JWT_TOKEN_KEY", base64.StdEncoding.EncodeToString(envKey))
+	defer func() {
+		signingKeyMu.Lock()
+		signingKey = nil
+		signingKeyMu.Unlock()
+	}()
+
+	t.Run("environment key without a configured key", func(t *testing.T) {
+		key, err := getSecretKey()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(key, envKey) {
+			t.Errorf("expected the JWT_TOKEN_KEY key, got %q", key)
+		}
+	})
+
+	t.Run("configured key is preferred", func(t *testing.T) {
+		if err := SetSigningKey(base64.StdEncoding.EncodeToString(configuredKey)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		key, err := getSecretKey()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(key, configuredKey) {
+			t.Errorf("expected the configured key, got %q", key)
+		}
+	})
+
+	t.Run("short keys are refused", func(t *testing.T) {
+		if err := SetSigningKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+			t.Error("expected an error for a short key")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeServer is an http.Handler answering the message_templates calls of the Business
+// Management API like Meta does, keeping templates in memory. New templates get
+// CreateStatus; use SetStatus to simulate reviews, pauses or rejections.
+type FakeServer struct {
+	CreateStatus string
+
+	mu        sync.Mutex
+	templates []Template
+	nextID    int
+}
+
+func NewFakeServer() *FakeServer {
+	return &FakeServer{CreateStatus: TemplateStatusApproved}
+}
+
+// ServeHTTP handles "/<business account id>/message_templates" requests.
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[len(parts)-1] != "message_templates" {
+		writeFakeError(w, http.StatusNotFound, "Unknown path")
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeFakeError(w, http.StatusUnauthorized, "Missing access token")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(listTemplatesResponse{Data: f.Templates()})
+	case "POST":
+		var template Template
+		if err := json.NewDecoder(r.Body).Decode(&template); err != nil || template.Name == "" || template.Language == "" {
+			writeFakeError(w, http.StatusBadRequest, "Invalid parameter")
+			return
+		}
+		json.NewEncoder(w).Encode(f.addTemplate(template))
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, "Unsupported method")
+	}
+}
+
+func (f *FakeServer) addTemplate(template Template) CreateTemplateResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	template.ID = fmt.Sprintf("%d", f.nextID)
+	template.Status = f.CreateStatus
+	f.templates = append(f.templates, template)
+	return CreateTemplateResponse{ID: template.ID, Status: template.Status, Category: template.Category}
+}
+
+// AddTemplate stores a template as if it had been created and reviewed with status.
+func (f *FakeServer) AddTemplate(name, language, category, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	f.templates = append(f.templates, Template{
+		ID:       fmt.Sprintf("%d", f.nextID),
+		Name:     name,
+		Language: language,
+		Category: category,
+		Status:   status,
+	})
+}
+
+// SetStatus changes the status of the template name in language. It returns false if there is
+// no such template.
+func (f *FakeServer) SetStatus(name, language, status string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.templates {
+		if f.templates[i].Name == name && f.templates[i].Language == language {
+			f.templates[i].Status = status
+			return true
+		}
+	}
+	return false
+}
+
+// Templates returns the templates stored so far.
+func (f *FakeServer) Templates() []Template {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Template{}, f.templates...)
+}
+
+func writeFakeError(w http.ResponseWriter, statusCode int, message string) {
+	w.WriteHeader(statusCode)
+	var response apiError
+	response.Error.Message = message
+	response.Error.Type = "OAuthException"
+	response.Error.Code = 100
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,136 @@
+// Package whatsapp manages the message templates of a WhatsApp Business Account through the
+// Business Management API, with a fake API server to run against locally.
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultAPIURL = "https://graph.facebook.com/v19.0"
+
+// TemplateClient lists, creates and checks the templates of one business account.
+type TemplateClient struct {
+	baseURL           string
+	businessAccountID string
+	accessToken       func() string
+	httpClient        *http.Client
+}
+
+// NewTemplateClient returns a client for the business account, talking to baseURL
+// (DefaultAPIURL if empty). accessToken is called for every request, so a rotated token is
+// picked up.
+func NewTemplateClient(baseURL, businessAccountID string, accessToken func() string) *TemplateClient {
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+	return &TemplateClient{
+		baseURL:           strings.TrimSuffix(baseURL, "/"),
+		businessAccountID: businessAccountID,
+		accessToken:       accessToken,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Configured reports whether the business account and access token are set.
+func (c *TemplateClient) Configured() bool {
+	return c.businessAccountID != "" && c.accessToken() != ""
+}
+
+// ListTemplates returns all templates of the account, in every language, following pagination.
+func (c *TemplateClient) ListTemplates(ctx context.Context) ([]Template, error) {
+	query := url.Values{}
+	query.Set("fields", "id,name,language,status,category,components")
+	query.Set("limit", "100")
+	next := fmt.Sprintf("%s/%s/message_templates?%s", c.baseURL, c.businessAccountID, query.Encode())
+
+	templates := []Template{}
+	for next != "" {
+		var page listTemplatesResponse
+		if err := c.do(ctx, "GET", next, nil, &page); err != nil {
+			return nil, err
+		}
+		templates = append(templates, page.Data...)
+		next = page.Paging.Next
+	}
+	return templates, nil
+}
+
+// GetTemplateStatus returns the review status of the template name in language, or "" if the
+// account has no such template.
+func (c *TemplateClient) GetTemplateStatus(ctx context.Context, name, language string) (string, error) {
+	templates, err := c.ListTemplates(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, template := range templates {
+		if template.Name == name && template.Language == language {
+			return template.Status, nil
+		}
+	}
+	return "", nil
+}
+
+// CreateTemplate submits a new template (or a new language of an existing one) for review.
+func (c *TemplateClient) CreateTemplate(ctx context.Context, template Template) (CreateTemplateResponse, error) {
+	var response CreateTemplateResponse
+	template.ID, template.Status = "", ""
+	err := c.do(ctx, "POST", fmt.Sprintf("%s/%s/message_templates", c.baseURL, c.businessAccountID), template, &response)
+	return response, err
+}
+
+func (c *TemplateClient) do(ctx context.Context, method, url string, payload interface{}, result interface{}) error {
+	if !c.Configured() {
+		return fmt.Errorf("WhatsApp business account not configured")
+	}
+
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.accessToken()))
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr apiError
+		if err := json.Unmarshal(respBody, &apiErr); err == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("WhatsApp API error: %s (code: %d)", apiErr.Error.Message, apiErr.Error.Code)
+		}
+		return fmt.Errorf("WhatsApp API error: status %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
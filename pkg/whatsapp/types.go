@@ -0,0 +1,72 @@
+package whatsapp
+
+import "strings"
+
+// Review statuses of a message template. Only APPROVED templates can be sent.
+const (
+	TemplateStatusApproved = "APPROVED"
+	TemplateStatusPending  = "PENDING"
+	TemplateStatusRejected = "REJECTED"
+	TemplateStatusPaused   = "PAUSED"
+	TemplateStatusDisabled = "DISABLED"
+)
+
+// Template is a message template of the WhatsApp Business Account, in one language.
+type Template struct {
+	ID         string              `json:"id,omitempty"`
+	Name       string              `json:"name"`
+	Language   string              `json:"language"`
+	Status     string              `json:"status,omitempty"`
+	Category   string              `json:"category"`
+	Components []TemplateComponent `json:"components,omitempty"`
+}
+
+// TemplateComponent is a header, body, footer or buttons part of a template definition.
+type TemplateComponent struct {
+	Type    string           `json:"type"`
+	Format  string           `json:"format,omitempty"`
+	Text    string           `json:"text,omitempty"`
+	Buttons []TemplateButton `json:"buttons,omitempty"`
+	// Footer of authentication templates: the validity of the code stated in the message
+	CodeExpirationMinutes int `json:"code_expiration_minutes,omitempty"`
+}
+
+// CodeExpirationMinutes returns the code validity an authentication template states, 0 if it
+// states none.
+func (t Template) CodeExpirationMinutes() int {
+	for _, component := range t.Components {
+		if strings.EqualFold(component.Type, "FOOTER") {
+			return component.CodeExpirationMinutes
+		}
+	}
+	return 0
+}
+
+type TemplateButton struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	URL  string `json:"url,omitempty"`
+}
+
+// CreateTemplateResponse is returned when a template is submitted for review.
+type CreateTemplateResponse struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Category string `json:"category"`
+}
+
+type listTemplatesResponse struct {
+	Data   []Template `json:"data"`
+	Paging struct {
+		Next string `json:"next,omitempty"`
+	} `json:"paging"`
+}
+
+// apiError is the error envelope of the Graph API.
+type apiError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
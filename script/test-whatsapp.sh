@@ -8,12 +8,12 @@ echo "================================================"
 
 # Configuration
 API_BASE_URL="http://localhost:3231"
-WHATSAPP_API_URL="https://graph.facebook.com/v19.0/676124925591256/messages"
-ACCESS_TOKEN="EAA6YlMvSeosBPM6NEv0SDKPu5IrTuAkLqL3jsQPglG181ZBD2bLy9P0TEtFJZBr064A5PFSc3fZAuZCMJeUKCkYbs2CN0vJkkuRLPJXqbaP8bpuX3ZC3PtX1yh7ZCexyjgTSjTy6PVUujRQ9cydJ4XV8ZBxYGRojZArolTo14YBnCgoJaf2VUdZBy1zOsQ4AyF1JrKD3gB64w8pBvhSTN1sDPyVjsSsoOiM25FyerpVdsqBVHaAZDZD"
-PHONE_NUMBER_ID="676124925591256"
+ACCESS_TOKEN="${WHATSAPP_API_TOKEN:?set WHATSAPP_API_TOKEN}"
+PHONE_NUMBER_ID="${WHATSAPP_PHONE_NUMBER_ID:?set WHATSAPP_PHONE_NUMBER_ID}"
+WHATSAPP_API_URL="https://graph.facebook.com/v19.0/$PHONE_NUMBER_ID/messages"
 
 # Test phone number (use your own WhatsApp number for testing)
-TEST_PHONE_NUMBER="+393930238386"  # Replace with your actual WhatsApp number
+TEST_PHONE_NUMBER="${TEST_PHONE_NUMBER:?set TEST_PHONE_NUMBER to your WhatsApp number}"
 
 echo "📱 Testing direct WhatsApp API call..."
 
@@ -1,5 +1,5 @@
 import { apiBase } from '../constants';
-import { WhatsAppVerificationRequest, WhatsAppVerificationResponse, VerifyCodeRequest, VerifyCodeResponse } from '../types/verification';
+import { PhoneVerificationMethod, VerificationPendingResponse, WhatsAppVerificationRequest, WhatsAppVerificationResponse, VerifyCodeRequest, VerifyCodeResponse } from '../types/verification';
 
 export interface User {
   id: string;
@@ -32,15 +32,15 @@ export const getUserReq = async (): Promise<ApiResponse<User>> => {
   return response.json();
 };
 
-export const addPhoneReq = async (phoneNumber: string, verificationMethod: 'whatsapp' | 'sms' = 'whatsapp'): Promise<ApiResponse<{ verificationToken: string }>> => {
+export const addPhoneReq = async (phoneNumber: string, verificationMethod: PhoneVerificationMethod = 'whatsapp', whatsappOptIn = false): Promise<ApiResponse<{ verificationToken: string }> & Partial<VerificationPendingResponse>> => {
   const token = localStorage.getItem('authToken') || '';
   const response = await fetch(`${apiBase}/user/phone/add`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': token,
+      'Authorization': `Bearer ${token}`,
     },
-    body: JSON.stringify({ phoneNumber, verificationMethod }),
+    body: JSON.stringify({ phoneNumber, verificationMethod, whatsappOptIn }),
   });
 
   if (!response.ok) {
@@ -50,15 +50,15 @@ export const addPhoneReq = async (phoneNumber: string, verificationMethod: 'what
   return response.json();
 };
 
-export const changePhoneReq = async (newPhoneNumber: string, verificationMethod: 'whatsapp' | 'sms' = 'whatsapp'): Promise<ApiResponse<{ verificationToken: string }>> => {
+export const changePhoneReq = async (newPhoneNumber: string, verificationMethod: PhoneVerificationMethod = 'whatsapp', whatsappOptIn = false): Promise<ApiResponse<{ verificationToken: string }> & Partial<VerificationPendingResponse>> => {
   const token = localStorage.getItem('authToken') || '';
   const response = await fetch(`${apiBase}/user/phone/change`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': token,
+      'Authorization': `Bearer ${token}`,
     },
-    body: JSON.stringify({ newPhoneNumber, verificationMethod }),
+    body: JSON.stringify({ newPhoneNumber, verificationMethod, whatsappOptIn }),
   });
 
   if (!response.ok) {
@@ -75,7 +75,7 @@ export const initiateWhatsAppVerificationReq = async (request: WhatsAppVerificat
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': token,
+      'Authorization': `Bearer ${token}`,
     },
     body: JSON.stringify({
       newPhone: request.phoneNumber,
@@ -91,13 +91,13 @@ export const initiateWhatsAppVerificationReq = async (request: WhatsAppVerificat
   return response.json();
 };
 
-export const verifyWhatsAppReq = async (token: string, code: string): Promise<ApiResponse<{}>> => {
+export const verifyWhatsAppReq = async (token: string, code: string): Promise<ApiResponse<{}> & Partial<VerifyCodeResponse>> => {
   const authToken = localStorage.getItem('authToken') || '';
   const response = await fetch(`${apiBase}/user/contact/verify-phone`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': authToken,
+      'Authorization': `Bearer ${authToken}`,
     },
     body: JSON.stringify({ token, code }),
   });
@@ -116,7 +116,7 @@ export const resendWhatsAppCodeReq = async (token: string): Promise<WhatsAppVeri
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': authToken,
+      'Authorization': `Bearer ${authToken}`,
     },
     body: JSON.stringify({ token }),
   });
@@ -131,11 +131,11 @@ export const resendWhatsAppCodeReq = async (token: string): Promise<WhatsAppVeri
 
 export const cancelWhatsAppVerificationReq = async (token: string): Promise<ApiResponse<{}>> => {
   const authToken = localStorage.getItem('authToken') || '';
-  const response = await fetch(`${apiBase}/user/whatsapp-verification/cancel`, {
+  const response = await fetch(`${apiBase}/user/contact/cancel-verification`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'Authorization': authToken,
+      'Authorization': `Bearer ${authToken}`,
     },
     body: JSON.stringify({ token }),
   });
@@ -145,4 +145,80 @@ export const cancelWhatsAppVerificationReq = async (token: string): Promise<ApiR
   }
 
   return response.json();
-};
\ No newline at end of file
+};
+export const removePhoneReq = async (contactId: string, password?: string): Promise<ApiResponse<{}>> => {
+  const authToken = localStorage.getItem('authToken') || '';
+  const response = await fetch(`${apiBase}/user/contact/remove-phone`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'Authorization': `Bearer ${authToken}`,
+    },
+    body: JSON.stringify({ contactId, password }),
+  });
+
+  if (!response.ok) {
+    throw new Error('Failed to remove phone number');
+  }
+
+  return response.json();
+};
+
+export const revokePhoneChangeReq = async (token: string): Promise<ApiResponse<{}>> => {
+  const response = await fetch(`${apiBase}/user/contact/revoke-phone-change`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ token }),
+  });
+
+  if (!response.ok) {
+    throw new Error('Failed to revoke phone change');
+  }
+
+  return response.json();
+};
+
+export type NotificationChannel = 'email' | 'whatsapp';
+
+export interface NotificationPreferences {
+  // Channel per message type: 'newsletter', 'weekly-reminder', 'account-security'; empty means default
+  channels: Record<string, NotificationChannel | ''>;
+  updatedAt: number;
+}
+
+export const getNotificationPreferencesReq = async (): Promise<ApiResponse<NotificationPreferences>> => {
+  const token = localStorage.getItem('authToken') || '';
+  const response = await fetch(`${apiBase}/user/notification-preferences`, {
+    method: 'GET',
+    headers: {
+      'Authorization': `Bearer ${token}`,
+    },
+  });
+
+  if (!response.ok) {
+    throw new Error('Failed to get notification preferences');
+  }
+
+  return response.json();
+};
+
+export const updateNotificationPreferencesReq = async (channels: Record<string, NotificationChannel | ''>): Promise<ApiResponse<NotificationPreferences>> => {
+  const token = localStorage.getItem('authToken') || '';
+  const response = await fetch(`${apiBase}/user/notification-preferences`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'Authorization': `Bearer ${token}`,
+    },
+    body: JSON.stringify({ channels }),
+  });
+
+  if (!response.ok) {
+    const errorData = await response.json();
+    throw new Error(errorData.message || 'Failed to update notification preferences');
+  }
+
+  return response.json();
+};
@@ -5,6 +5,7 @@ import { useDispatch } from 'react-redux';
 import { dialogActions } from '../../../store/dialogSlice';
 import { userActions } from '../../../store/userSlice';
 import { getUserReq, addPhoneReq } from '../../../api/userAPI';
+import { PhoneVerificationMethod } from '../../../types/verification';
 
 interface AddPhoneProps {
   onClose: () => void;
@@ -15,7 +16,8 @@ const AddPhone: React.FC<AddPhoneProps> = ({ onClose }) => {
   const dispatch = useDispatch();
   const [formData, setFormData] = useState({
     newPhone: '',
-    verificationMethod: 'whatsapp' as 'whatsapp' | 'sms'
+    verificationMethod: 'whatsapp' as PhoneVerificationMethod,
+    whatsappOptIn: false
   });
   const [isLoading, setIsLoading] = useState(false);
   const [error, setError] = useState('');
@@ -29,7 +31,7 @@ const AddPhone: React.FC<AddPhoneProps> = ({ onClose }) => {
     }));
   };
 
-  const handleMethodChange = (method: 'whatsapp' | 'sms') => {
+  const handleMethodChange = (method: PhoneVerificationMethod) => {
     setFormData(prev => ({
       ...prev,
       verificationMethod: method
@@ -54,7 +56,7 @@ const AddPhone: React.FC<AddPhoneProps> = ({ onClose }) => {
     setError('');
 
     try {
-      const response = await addPhoneReq(formData.newPhone, formData.verificationMethod);
+      const response = await addPhoneReq(formData.newPhone, formData.verificationMethod, formData.whatsappOptIn);
       if (response.success) {
         if (response.user) {
           dispatch(userActions.setUser(response.user));
@@ -68,6 +70,8 @@ const AddPhone: React.FC<AddPhoneProps> = ({ onClose }) => {
             phoneNumber: formData.newPhone,
             token: response.data?.verificationToken || response.verificationToken || '',
             verificationMethod: formData.verificationMethod,
+            deepLink: response.deepLink,
+            reverseInstructions: response.reverseInstructions,
           }
         }));
       } else {
@@ -95,25 +99,59 @@ const AddPhone: React.FC<AddPhoneProps> = ({ onClose }) => {
                 <input
                   type="radio"
                   name="verificationMethod"
-                  value="sms"
-                  checked={formData.verificationMethod === 'sms'}
-                  onChange={() => handleMethodChange('sms')}
+                  value="whatsapp"
+                  checked={formData.verificationMethod === 'whatsapp'}
+                  onChange={() => handleMethodChange('whatsapp')}
                 />
-                {t('dialogs.addPhone.warningDialog.smsOption')}
+                {t('dialogs.addPhone.warningDialog.whatsappOption')}
               </label>
               <label className="radio-option">
                 <input
                   type="radio"
                   name="verificationMethod"
-                  value="whatsapp"
-                  checked={formData.verificationMethod === 'whatsapp'}
-                  onChange={() => handleMethodChange('whatsapp')}
+                  value="voice"
+                  checked={formData.verificationMethod === 'voice'}
+                  onChange={() => handleMethodChange('voice')}
                 />
-                {t('dialogs.addPhone.warningDialog.whatsappOption')}
+                {t('dialogs.addPhone.warningDialog.voiceOption')}
+              </label>
+              <label className="radio-option">
+                <input
+                  type="radio"
+                  name="verificationMethod"
+                  value="telegram"
+                  checked={formData.verificationMethod === 'telegram'}
+                  onChange={() => handleMethodChange('telegram')}
+                />
+                {t('dialogs.addPhone.warningDialog.telegramOption')}
+              </label>
+              <label className="radio-option">
+                <input
+                  type="radio"
+                  name="verificationMethod"
+                  value="whatsapp-reverse"
+                  checked={formData.verificationMethod === 'whatsapp-reverse'}
+                  onChange={() => handleMethodChange('whatsapp-reverse')}
+                />
+                {t('dialogs.addPhone.warningDialog.whatsappReverseOption')}
               </label>
             </div>
           </div>
 
+          {formData.verificationMethod === 'whatsapp' && (
+            <div className="form-group">
+              <label className="checkbox-option">
+                <input
+                  type="checkbox"
+                  name="whatsappOptIn"
+                  checked={formData.whatsappOptIn}
+                  onChange={(e) => setFormData(prev => ({ ...prev, whatsappOptIn: e.target.checked }))}
+                />
+                {t('dialogs.addPhone.warningDialog.whatsappOptIn')}
+              </label>
+            </div>
+          )}
+
           {error && <div className="error-message">{error}</div>}
           
           <div className="dialog-actions">
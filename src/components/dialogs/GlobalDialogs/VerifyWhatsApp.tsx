@@ -4,11 +4,16 @@ import { useDispatch } from 'react-redux';
 
 import { dialogActions } from '../../../store/dialogSlice';
 import { verifyWhatsAppReq, resendWhatsAppCodeReq, cancelWhatsAppVerificationReq } from '../../../api/userAPI';
+import { PhoneVerificationMethod, ReverseVerificationInstructions } from '../../../types/verification';
 
 interface VerifyWhatsAppProps {
   phoneNumber: string;
   token: string;
-  verificationMethod?: 'whatsapp' | 'sms';
+  verificationMethod?: PhoneVerificationMethod;
+  // Telegram only: link starting the bot, where the code is delivered
+  deepLink?: string;
+  // whatsapp-reverse only: message to send from the phone instead of entering a code
+  reverseInstructions?: ReverseVerificationInstructions;
   onClose: () => void;
 }
 
@@ -16,6 +21,8 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
   phoneNumber, 
   token, 
   verificationMethod = 'whatsapp', 
+  deepLink,
+  reverseInstructions: initialReverseInstructions,
   onClose 
 }) => {
   const { t } = useTranslation();
@@ -26,6 +33,7 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
   const [error, setError] = useState('');
   const [attemptsRemaining, setAttemptsRemaining] = useState<number | null>(null);
   const [timeRemaining, setTimeRemaining] = useState<number>(600); // 10 minutes in seconds
+  const [reverseInstructions, setReverseInstructions] = useState(initialReverseInstructions);
 
   // Countdown timer effect
   React.useEffect(() => {
@@ -67,7 +75,10 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
     try {
       const response = await verifyWhatsAppReq(token, verificationCode);
       
-      if (response.success) {
+      if (response.success && response.reverseInstructions) {
+        // Current number confirmed: the new one is verified by sending the code shown
+        setReverseInstructions(response.reverseInstructions);
+      } else if (response.success) {
         // Show success message briefly before closing
         setError(t('dialogs.verifyWhatsApp.success'));
         setTimeout(() => {
@@ -126,6 +137,7 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
       if (response.success) {
         setTimeRemaining(600); // Reset timer to 10 minutes
         setAttemptsRemaining(response.attemptsRemaining);
+        setReverseInstructions(response.reverseInstructions);
         setVerificationCode(''); // Clear current input
         // Don't show success message to avoid confusion
       } else {
@@ -157,7 +169,14 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
   }, [verificationCode]);
 
   const isExpired = timeRemaining === 0;
-  const methodName = verificationMethod === 'whatsapp' ? 'WhatsApp' : 'SMS';
+  const methodNames: Record<PhoneVerificationMethod, string> = {
+    whatsapp: 'WhatsApp',
+    sms: 'SMS',
+    voice: t('dialogs.verifyWhatsApp.voiceCall'),
+    telegram: 'Telegram',
+    'whatsapp-reverse': 'WhatsApp',
+  };
+  const methodName = methodNames[verificationMethod];
 
   return (
     <div className="dialog-overlay">
@@ -167,13 +186,32 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
           {t('dialogs.verifyWhatsApp.description', { phoneNumber, method: methodName })}
         </p>
         
+        {verificationMethod === 'telegram' && deepLink && (
+          <div className="telegram-link">
+            <p>{t('dialogs.verifyWhatsApp.telegramInstructions')}</p>
+            <a href={deepLink} target="_blank" rel="noopener noreferrer" className="button primary">
+              {t('dialogs.verifyWhatsApp.openTelegramBtn')}
+            </a>
+          </div>
+        )}
+
+        {reverseInstructions && (
+          <div className="whatsapp-reverse-instructions">
+            <p>{t('dialogs.verifyWhatsApp.whatsappReverseInstructions', { businessPhoneNumber: reverseInstructions.businessPhoneNumber })}</p>
+            <p className="whatsapp-reverse-message">{reverseInstructions.messageText}</p>
+            <a href={reverseInstructions.whatsappLink} target="_blank" rel="noopener noreferrer" className="button primary">
+              {t('dialogs.verifyWhatsApp.openWhatsAppBtn')}
+            </a>
+          </div>
+        )}
+
         <div className="verification-timer">
           <span className={`timer ${timeRemaining < 60 ? 'warning' : ''}`}>
             {t('dialogs.verifyWhatsApp.timeRemaining')}: {formatTime(timeRemaining)}
           </span>
         </div>
         
-        <div className="form-group">
+        {!reverseInstructions && <div className="form-group">
           <label htmlFor="verificationCode">{t('dialogs.verifyWhatsApp.codeInputLabel')}</label>
           <input
             type="text"
@@ -191,7 +229,7 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
           <div className="input-hint">
             {verificationCode.length}/6 {t('dialogs.verifyWhatsApp.digitsEntered')}
           </div>
-        </div>
+        </div>}
 
         {attemptsRemaining !== null && attemptsRemaining > 0 && (
           <div className="attempts-remaining">
@@ -219,14 +257,14 @@ const VerifyWhatsApp: React.FC<VerifyWhatsAppProps> = ({
             {isResending ? t('dialogs.verifyWhatsApp.resending') : t('dialogs.verifyWhatsApp.resendBtn')}
           </button>
           
-          <button 
+          {!reverseInstructions && <button 
             type="button" 
             onClick={handleVerify} 
             disabled={isVerifying || !verificationCode.trim() || verificationCode.length !== 6 || isExpired}
             className="primary"
           >
             {isVerifying ? t('dialogs.verifyWhatsApp.verifying') : t('dialogs.verifyWhatsApp.verifyBtn')}
-          </button>
+          </button>}
         </div>
       </div>
     </div>
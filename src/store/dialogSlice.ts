@@ -1,3 +1,5 @@
+import { PhoneVerificationMethod, ReverseVerificationInstructions } from '../types/verification';
+
 export interface DialogState {
   isOpen: boolean;
   type: string | null;
@@ -19,7 +21,7 @@ export const dialogActions = {
     type: 'dialog/openAlertDialog',
     payload
   }),
-  openVerifyWhatsAppDialog: (payload: { type: string; payload: { phoneNumber: string; token: string; verificationMethod?: 'whatsapp' | 'sms' } }) => ({
+  openVerifyWhatsAppDialog: (payload: { type: string; payload: { phoneNumber: string; token: string; verificationMethod?: PhoneVerificationMethod; deepLink?: string; reverseInstructions?: ReverseVerificationInstructions } }) => ({
     type: 'dialog/openVerifyWhatsAppDialog',
     payload
   }),
@@ -12,9 +12,22 @@ export interface VerificationAttempt {
   maxRetries: number;
 }
 
+// Channels a phone verification code can be requested over
+// ('whatsapp-reverse': no code is sent, the user sends the code shown to our WhatsApp number)
+export type PhoneVerificationMethod = 'whatsapp' | 'sms' | 'voice' | 'telegram' | 'whatsapp-reverse';
+
+// Message to send from the phone being verified to the WhatsApp business number
+export interface ReverseVerificationInstructions {
+  businessPhoneNumber: string;
+  messageText: string;
+  whatsappLink: string;
+  maskedPhoneNumber: string;
+  expiresAt: number;
+}
+
 export interface WhatsAppVerificationRequest {
   phoneNumber: string;
-  verificationMethod: 'whatsapp' | 'sms';
+  verificationMethod: PhoneVerificationMethod;
 }
 
 export interface WhatsAppVerificationResponse {
@@ -23,6 +36,26 @@ export interface WhatsAppVerificationResponse {
   message: string;
   expiresAt: Date;
   attemptsRemaining: number;
+  reverseInstructions?: ReverseVerificationInstructions;
+}
+
+export interface VerificationPendingResponse {
+  success: boolean;
+  status: 'pending';
+  verificationToken: string;
+  verificationMethod: PhoneVerificationMethod | 'email-link';
+  maskedDestination: string;
+  // Telegram only: link starting the bot, where the code is delivered
+  deepLink?: string;
+  step?: 'verify-old-phone' | 'verify-new-phone';
+  expiresAt: string;
+  resendAvailableAt: string;
+  attemptsRemaining: number;
+  message?: string;
+  // Set when the number is accepted with a caveat, e.g. 'PHONE_ALREADY_IN_USE'
+  warning?: string;
+  // whatsapp-reverse only
+  reverseInstructions?: ReverseVerificationInstructions;
 }
 
 export interface VerifyCodeRequest {
@@ -34,5 +67,8 @@ export interface VerifyCodeResponse {
   success: boolean;
   message: string;
   verified: boolean;
+  step?: 'verify-old-phone' | 'verify-new-phone';
   attemptsRemaining?: number;
+  // Set when the new number is verified with whatsapp-reverse
+  reverseInstructions?: ReverseVerificationInstructions;
 }
\ No newline at end of file